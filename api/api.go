@@ -1,12 +1,17 @@
 package api
 
 import (
-	"errors"
+	"net"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/dns"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
 	"github.com/lwmacct/250402-m-geoip/api/v10/models"
 	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/clientip"
+	"github.com/lwmacct/250402-m-geoip/internal/proxyproto"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -51,6 +56,23 @@ func New() *mux {
 	// gin.SetMode(gin.DebugMode)
 	r := gin.New()
 	r.Use(gin.Recovery())
+
+	// gin 默认信任所有地址发来的转发请求头, 改为只信任配置的代理
+	// Forwarded 请求头 gin 无法解析, 由 clientip 处理, 这里只影响 c.ClientIP()
+	proxy := geoip.ClientIPResolver()
+	var trusted, headers []string
+	for _, p := range proxy.Trusted() {
+		trusted = append(trusted, p.String())
+	}
+	for _, h := range app.Flag.App.Proxy.Headers {
+		if h = strings.TrimSpace(h); h != "" && !strings.EqualFold(h, clientip.HeaderForwarded) {
+			headers = append(headers, h)
+		}
+	}
+	if err := r.SetTrustedProxies(trusted); err != nil {
+		mlog.Error(mlog.H{"msg": "api.New", "err": err, "detail": "SetTrustedProxies failed"})
+	}
+	r.RemoteIPHeaders = headers
 	return &mux{router: r}
 }
 
@@ -65,24 +87,83 @@ func (t *mux) Run() {
 		return
 	}
 
-	{
-		// 判断数据库是否为空,如果为空则导入IP地理位置数据
-		record := &models.GeoIPV10{}
-		if err := app.DB.Take(record).Error; errors.Is(err, gorm.ErrRecordNotFound) {
-			mlog.Info(mlog.H{"msg": "api.Run", "data": "InsertGeoIP"})
-			record.InsertSampleData(app.DB)
-		}
-	}
-
 	// 注册路由并启动服务器
 	t.register()
 	mlog.Info(mlog.H{"msg": "api.Run", "data": "Server starting on " + app.Flag.App.ListenAddr})
-	if err := t.router.Run(app.Flag.App.ListenAddr); err != nil {
+	listener, err := t.listen()
+	if err != nil {
+		mlog.Error(mlog.H{"msg": "api.Run", "err": err})
+		return
+	}
+	if err := t.serveDNS(); err != nil {
+		listener.Close()
+		mlog.Error(mlog.H{"msg": "api.Run", "err": err})
+		return
+	}
+	if err := t.router.RunListener(listener); err != nil {
 		mlog.Error(mlog.H{"msg": "api.Run", "err": err})
 	}
 	mlog.Info(mlog.H{"msg": "api.Run", "data": "Run completed"})
 }
 
+// listen 监听 HTTP 端口, 启用 PROXY protocol 时只解析来自配置上游的连接
+func (t *mux) listen() (net.Listener, error) {
+	listener, err := net.Listen("tcp", app.Flag.App.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	opt := app.Flag.App.ProxyProtocol
+	if !opt.Enable {
+		return listener, nil
+	}
+
+	upstream, err := proxyproto.ParseUpstream(opt.Upstream)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if len(upstream) == 0 {
+		mlog.Warn(mlog.H{"msg": "api.listen", "detail": "PROXY protocol 已启用但未配置上游地址, 所有连接按普通连接处理"})
+	}
+	mlog.Info(mlog.H{"msg": "api.listen", "detail": "PROXY protocol enabled", "upstream": upstream})
+	return proxyproto.NewListener(listener, upstream, opt.Timeout), nil
+}
+
+// serveDNS 启用时在后台提供 DNS TXT 查询, UDP 与 TCP 使用同一个地址
+func (t *mux) serveDNS() error {
+	opt := app.Flag.App.DNS
+	if !opt.Enable {
+		return nil
+	}
+
+	srv, err := dns.New(new(geoip.SrvDBQuery).Init(), opt.Zone, opt.TTL)
+	if err != nil {
+		return err
+	}
+	udp, err := net.ListenPacket("udp", opt.ListenAddr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", opt.ListenAddr)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+
+	mlog.Info(mlog.H{"msg": "api.serveDNS", "data": "DNS server starting on " + opt.ListenAddr, "zone": opt.Zone})
+	go func() {
+		if err := srv.ServeUDP(udp); err != nil {
+			mlog.Error(mlog.H{"msg": "api.serveDNS", "err": err, "network": "udp"})
+		}
+	}()
+	go func() {
+		if err := srv.ServeTCP(tcp); err != nil {
+			mlog.Error(mlog.H{"msg": "api.serveDNS", "err": err, "network": "tcp"})
+		}
+	}()
+	return nil
+}
+
 func (t *mux) Test() {
 
 }
@@ -0,0 +1,313 @@
+// Package dns 实现通过 DNS TXT 记录查询地理位置的服务, 查询方式与 Team Cymru 的 IP to ASN 服务相同
+//
+// IPv4 查询 4.3.2.1.origin.<zone>, IPv6 查询按半字节倒序的 <nibbles>.origin6.<zone> (可以只给出前面的部分半字节, 其余补 0),
+// 返回 "CIDR | ASN | country_code | province | city | isp";
+// 未找到记录返回 NXDOMAIN, 后端错误返回 SERVFAIL, 不属于 zone 的查询返回 REFUSED
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 查询名称中 zone 之前的标签
+const (
+	labelOrigin  = "origin"
+	labelOrigin6 = "origin6"
+)
+
+const (
+	udpMinSize   = 512  // 没有 EDNS 时 UDP 响应的长度上限
+	udpMaxSize   = 4096 // EDNS 声明的 UDP 长度超过该值时按该值处理
+	tcpIdle      = 10 * time.Second
+	txtMaxLength = 255 // 单个 TXT 字符串的长度上限
+)
+
+// Server DNS 查询服务, 与 HTTP 接口共用同一个查询服务
+type Server struct {
+	query *geoip.SrvDBQuery
+	zone  string // 小写, 以 . 结尾
+	ttl   uint32
+}
+
+// New 创建服务, zone 如 geo.local, ttl 为应答记录的有效期
+func New(query *geoip.SrvDBQuery, zone string, ttl time.Duration) (*Server, error) {
+	zone = strings.ToLower(strings.Trim(strings.TrimSpace(zone), "."))
+	if zone == "" {
+		return nil, fmt.Errorf("DNS zone 不能为空")
+	}
+	if _, err := dnsmessage.NewName(zone + "."); err != nil {
+		return nil, fmt.Errorf("无效的 DNS zone: %s: %v", zone, err)
+	}
+	if ttl < 0 {
+		return nil, fmt.Errorf("无效的 TTL: %s", ttl)
+	}
+	return &Server{query: query, zone: zone + ".", ttl: uint32(ttl / time.Second)}, nil
+}
+
+// ServeUDP 处理 UDP 查询, 连接关闭时返回 nil
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.handle(req, false); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP 处理 TCP 查询, 监听器关闭时返回 nil
+func (s *Server) ServeTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn 处理一个 TCP 连接, 每条消息前有 2 字节长度
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	var size [2]byte
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdle))
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		resp := s.handle(req, true)
+		if resp == nil {
+			return
+		}
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))); err != nil {
+			return
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle 处理一条查询, 无法解析的消息返回 nil 不应答
+func (s *Server) handle(req []byte, tcp bool) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(req)
+	if err != nil || header.Response {
+		return nil
+	}
+	resp := dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		Authoritative:    true,
+		RecursionDesired: header.RecursionDesired,
+	}
+	question, err := p.Question()
+	if err != nil {
+		resp.RCode = dnsmessage.RCodeFormatError
+		return s.reply(resp, nil, nil, 0)
+	}
+
+	// EDNS 声明的 UDP 长度
+	limit := udpMinSize
+	var edns bool
+	if tcp {
+		limit = 65535
+	}
+	if p.SkipAllQuestions() == nil && p.SkipAllAnswers() == nil && p.SkipAllAuthorities() == nil {
+		for {
+			h, err := p.AdditionalHeader()
+			if err != nil {
+				break
+			}
+			if h.Type == dnsmessage.TypeOPT {
+				edns = true
+				if size := int(h.Class); !tcp && size > limit {
+					limit = min(size, udpMaxSize)
+				}
+			}
+			if p.SkipAdditional() != nil {
+				break
+			}
+		}
+	}
+	opt := 0
+	if edns {
+		opt = min(limit, udpMaxSize)
+	}
+
+	if header.OpCode != 0 {
+		resp.RCode = dnsmessage.RCodeNotImplemented
+		return s.reply(resp, &question, nil, opt)
+	}
+	var txt []string
+	resp.RCode, txt = s.answer(question)
+	msg := s.reply(resp, &question, txt, opt)
+	if len(msg) > limit {
+		// 超出 UDP 长度时只返回截断标志, 由客户端改用 TCP 查询
+		resp.Truncated = true
+		msg = s.reply(resp, &question, nil, opt)
+	}
+	return msg
+}
+
+// answer 解析查询名称并查询, 返回响应码和 TXT 记录
+func (s *Server) answer(q dnsmessage.Question) (dnsmessage.RCode, []string) {
+	name := strings.ToLower(q.Name.String())
+	if q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		return dnsmessage.RCodeRefused, nil
+	}
+	if name == s.zone {
+		return dnsmessage.RCodeSuccess, nil
+	}
+	rel, ok := strings.CutSuffix(name, "."+s.zone)
+	if !ok {
+		return dnsmessage.RCodeRefused, nil
+	}
+
+	addr, ok := parseName(rel)
+	if !ok {
+		return dnsmessage.RCodeNameError, nil
+	}
+	result, err := s.query.Lookup(addr.String(), geoip.LookupOptions{Policy: geoip.DefaultPolicy()})
+	if err != nil {
+		if errors.Is(err, geoip.ErrBackend) {
+			mlog.Error(mlog.H{"msg": "dns 查询失败", "name": name, "err": err.Error()})
+			return dnsmessage.RCodeServerFailure, nil
+		}
+		return dnsmessage.RCodeNameError, nil
+	}
+	if q.Type != dnsmessage.TypeTXT && q.Type != dnsmessage.TypeALL {
+		// 名称存在但没有该类型的记录
+		return dnsmessage.RCodeSuccess, nil
+	}
+	return dnsmessage.RCodeSuccess, split(format(result.Record))
+}
+
+// parseName 解析 zone 之前的部分, 如 4.3.2.1.origin 或 b.a.9.8.origin6
+func parseName(rel string) (netip.Addr, bool) {
+	labels := strings.Split(rel, ".")
+	kind := labels[len(labels)-1]
+	labels = labels[:len(labels)-1]
+
+	switch kind {
+	case labelOrigin:
+		if len(labels) != net.IPv4len {
+			return netip.Addr{}, false
+		}
+		var b [4]byte
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 10, 8)
+			if err != nil || (len(label) > 1 && label[0] == '0') {
+				return netip.Addr{}, false
+			}
+			b[3-i] = byte(v)
+		}
+		return netip.AddrFrom4(b), true
+
+	case labelOrigin6:
+		if len(labels) == 0 || len(labels) > 32 {
+			return netip.Addr{}, false
+		}
+		var b [16]byte
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return netip.Addr{}, false
+			}
+			// 倒序: 最后一个标签是最高位的半字节
+			n := len(labels) - 1 - i
+			b[n/2] |= byte(v) << (4 * (1 - n%2))
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return netip.Addr{}, false
+}
+
+// format 生成 "CIDR | ASN | country_code | province | city | isp"
+func format(g models.GeoIPV10) string {
+	asn := ""
+	if g.ASN != 0 {
+		asn = strconv.Itoa(g.ASN)
+	}
+	return strings.Join([]string{g.Cidr, asn, g.CountryCode, g.Province, g.City, g.ISP}, " | ")
+}
+
+// split 按 TXT 字符串的长度上限拆分, 客户端拼接后得到原文
+func split(s string) []string {
+	var parts []string
+	for len(s) > txtMaxLength {
+		parts = append(parts, s[:txtMaxLength])
+		s = s[txtMaxLength:]
+	}
+	return append(parts, s)
+}
+
+// reply 生成响应, opt 大于 0 时附带 EDNS OPT 记录
+func (s *Server) reply(header dnsmessage.Header, q *dnsmessage.Question, txt []string, opt int) []byte {
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil
+	}
+	if q != nil {
+		if err := b.Question(*q); err != nil {
+			return nil
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil
+	}
+	if txt != nil {
+		h := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: s.ttl}
+		if err := b.TXTResource(h, dnsmessage.TXTResource{TXT: txt}); err != nil {
+			return nil
+		}
+	}
+	if opt > 0 {
+		if err := b.StartAdditionals(); err != nil {
+			return nil
+		}
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(opt, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil
+		}
+		if err := b.OPTResource(h, dnsmessage.OPTResource{}); err != nil {
+			return nil
+		}
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+)
+
+func TestParseName(t *testing.T) {
+	full := "b.a.9.8.7.6.5.4.3.2.1.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"
+	tests := []struct {
+		name string
+		want string // 为空表示名称无效
+	}{
+		{"4.3.2.1.origin", "1.2.3.4"},
+		{"0.0.0.0.origin", "0.0.0.0"},
+		{"255.255.255.255.origin", "255.255.255.255"},
+		{"3.2.1.origin", ""},
+		{"5.4.3.2.1.origin", ""},
+		{"256.3.2.1.origin", ""},
+		{"04.3.2.1.origin", ""},
+		{"a.3.2.1.origin", ""},
+		{"origin", ""},
+
+		// 完整的 32 个半字节
+		{full + ".origin6", "2001:db8::123:4567:89ab"},
+		{strings.ToUpper(full) + ".origin6", "2001:db8::123:4567:89ab"},
+		// 半字节不足 32 个时为网络地址, 低位补零
+		{"8.b.d.0.1.0.0.2.origin6", "2001:db8::"},
+		{"2.origin6", "2000::"},
+		{"1." + full + ".origin6", ""},
+		{"origin6", ""},
+		{"10.0.0.2.origin6", ""},
+		{"g.0.0.2.origin6", ""},
+		{"8..0.2.origin6", ""},
+
+		{"4.3.2.1.origin7", ""},
+		{"4.3.2.1", ""},
+	}
+	for _, tt := range tests {
+		addr, ok := parseName(tt.name)
+		if ok != (tt.want != "") || (ok && addr.String() != tt.want) {
+			t.Errorf("parseName(%q) = %s, %v, want %q", tt.name, addr, ok, tt.want)
+		}
+	}
+}
+
+func TestFormatSplit(t *testing.T) {
+	g := models.GeoIPV10{Cidr: "1.2.3.0/24", ASN: 4134, CountryCode: "CN", Province: "广东", City: "深圳", ISP: "电信"}
+	if got, want := format(g), "1.2.3.0/24 | 4134 | CN | 广东 | 深圳 | 电信"; got != want {
+		t.Errorf("format = %q, want %q", got, want)
+	}
+	if got, want := format(models.GeoIPV10{Cidr: "::/0"}), "::/0 |  |  |  |  | "; got != want {
+		t.Errorf("format without ASN = %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("x", txtMaxLength*2+1)
+	parts := split(long)
+	if len(parts) != 3 || strings.Join(parts, "") != long || !slices.ContainsFunc(parts, func(s string) bool { return len(s) == 1 }) {
+		t.Errorf("split into %d parts", len(parts))
+	}
+	if parts := split(""); len(parts) != 1 || parts[0] != "" {
+		t.Errorf("split(\"\") = %q", parts)
+	}
+}
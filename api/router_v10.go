@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lwmacct/250402-m-geoip/api/v10/cidr"
 	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
 )
 
@@ -21,5 +22,6 @@ func (t *routerV10) Init(router *gin.RouterGroup) {
 
 func (t *routerV10) Register() {
 	geoip.New(t.router)
+	cidr.New(t.router)
 
 }
@@ -0,0 +1,71 @@
+package cidr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lwmacct/250300-go-mod-mgin/pkg/mgin"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+)
+
+type main struct {
+	mgin.Handler
+	srv1 *SrvCIDR
+}
+
+func (t *main) Register(r *gin.RouterGroup) {
+	rg := r.Group("cidr")
+	rg.POST("aggregate", t.Aggregate)
+	rg.POST("subtract", t.Subtract)
+	rg.POST("intersect", t.Intersect)
+}
+
+// Aggregate 合并全部集合, 返回最少的 CIDR 列表
+func (t *main) Aggregate(c *gin.Context) {
+	t.run(c, t.srv1.Aggregate)
+}
+
+// Subtract 第一个集合减去其余集合
+func (t *main) Subtract(c *gin.Context) {
+	t.run(c, t.srv1.Subtract)
+}
+
+// Intersect 求全部集合的交集
+func (t *main) Intersect(c *gin.Context) {
+	t.run(c, t.srv1.Intersect)
+}
+
+// run 解析请求体并执行集合运算
+func (t *main) run(c *gin.Context, fn func(Request) (Result, error)) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		t.Return400(c, "无效的请求数据: "+err.Error())
+		return
+	}
+
+	result, err := fn(req)
+	if err != nil {
+		// 筛选条件查询数据库失败时返回 503, 其余为请求数据错误
+		if errors.Is(err, geoip.ErrBackend) {
+			t.Return503(c, err.Error())
+			return
+		}
+		t.Return400(c, err.Error())
+		return
+	}
+
+	response := mgin.Response[Result]{
+		Code: http.StatusOK,
+		Msg:  "success",
+		Data: result,
+	}
+	c.JSON(response.Code, response)
+}
+
+func New(router *gin.RouterGroup) *main {
+	t := &main{}
+	t.Register(router)
+	t.srv1 = new(SrvCIDR).Init()
+	return t
+}
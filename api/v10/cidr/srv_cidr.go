@@ -0,0 +1,149 @@
+package cidr
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+)
+
+// 单次请求中直接提供的前缀数量上限
+const maxPrefixes = 100000
+
+// Operand 参与运算的前缀集合, 由前缀列表与 geoip_v10 筛选结果合并而成
+type Operand struct {
+	Prefixes []string             `json:"prefixes"`
+	Filter   *geoip.NetworkFilter `json:"filter,omitempty"`
+}
+
+// Request 集合运算请求
+type Request struct {
+	Sets []Operand `json:"sets"`
+}
+
+// Result 运算结果, 覆盖结果地址的最少 CIDR 列表
+type Result struct {
+	Prefixes []string `json:"prefixes"`
+	Count    int      `json:"count"`
+}
+
+// SrvCIDR 前缀集合运算服务
+type SrvCIDR struct {
+	once   sync.Once
+	export *geoip.SrvDBExport
+}
+
+// Init 初始化服务
+func (t *SrvCIDR) Init() *SrvCIDR {
+	t.once.Do(func() {
+		t.export = new(geoip.SrvDBExport).Init()
+	})
+	return t
+}
+
+// Aggregate 返回全部集合的并集
+func (t *SrvCIDR) Aggregate(req Request) (Result, error) {
+	sets, err := t.resolve(req, 1)
+	if err != nil {
+		return Result{}, err
+	}
+	set := sets[0]
+	for _, s := range sets[1:] {
+		set = set.Union(s)
+	}
+	return newResult(set), nil
+}
+
+// Subtract 返回第一个集合减去其余集合后的结果
+func (t *SrvCIDR) Subtract(req Request) (Result, error) {
+	sets, err := t.resolve(req, 2)
+	if err != nil {
+		return Result{}, err
+	}
+	set := sets[0]
+	for _, s := range sets[1:] {
+		set = set.Subtract(s)
+	}
+	return newResult(set), nil
+}
+
+// Intersect 返回全部集合的交集
+func (t *SrvCIDR) Intersect(req Request) (Result, error) {
+	sets, err := t.resolve(req, 2)
+	if err != nil {
+		return Result{}, err
+	}
+	set := sets[0]
+	for _, s := range sets[1:] {
+		set = set.Intersect(s)
+	}
+	return newResult(set), nil
+}
+
+// resolve 解析请求中的每个集合, 至少需要 min 个
+func (t *SrvCIDR) resolve(req Request, min int) ([]*cidrset.Set, error) {
+	if len(req.Sets) < min {
+		return nil, fmt.Errorf("至少需要 %d 个集合", min)
+	}
+
+	total := 0
+	for _, op := range req.Sets {
+		total += len(op.Prefixes)
+	}
+	if total > maxPrefixes {
+		return nil, fmt.Errorf("前缀数量超过上限 %d", maxPrefixes)
+	}
+
+	sets := make([]*cidrset.Set, 0, len(req.Sets))
+	for i, op := range req.Sets {
+		set, err := t.operand(op)
+		if err != nil {
+			return nil, fmt.Errorf("sets[%d]: %w", i, err)
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// operand 解析前缀列表并合并筛选结果
+func (t *SrvCIDR) operand(op Operand) (*cidrset.Set, error) {
+	if len(op.Prefixes) == 0 && op.Filter == nil {
+		return nil, fmt.Errorf("prefixes 与 filter 不能同时为空")
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(op.Prefixes))
+	var invalid []string
+	for _, v := range op.Prefixes {
+		cidr, err := models.NormalizeCidr(strings.TrimSpace(v))
+		if err != nil {
+			invalid = append(invalid, v)
+			continue
+		}
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("无效的CIDR: %s", strings.Join(invalid, ", "))
+	}
+
+	if op.Filter != nil {
+		seeded, err := t.export.List(*op.Filter)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, seeded...)
+	}
+	return cidrset.New(prefixes...), nil
+}
+
+func newResult(set *cidrset.Set) Result {
+	result := Result{Prefixes: []string{}}
+	for _, p := range set.Prefixes() {
+		result.Prefixes = append(result.Prefixes, p.String())
+	}
+	result.Count = len(result.Prefixes)
+	return result
+}
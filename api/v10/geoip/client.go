@@ -0,0 +1,42 @@
+package geoip
+
+import (
+	"sync"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/clientip"
+)
+
+var (
+	clientIPOnce     sync.Once
+	clientIPResolver *clientip.Resolver
+)
+
+// ClientIPResolver 返回按 --app-proxy-* 配置的客户端IP解析器, 配置无效时不信任任何转发请求头
+func ClientIPResolver() *clientip.Resolver {
+	clientIPOnce.Do(func() {
+		resolver, err := clientip.New(app.Flag.App.Proxy.Trusted, app.Flag.App.Proxy.Headers)
+		if err != nil {
+			mlog.Error(mlog.H{"msg": "可信代理配置无效, 不信任任何转发请求头", "err": err.Error()})
+			resolver, _ = clientip.New(nil, nil)
+		}
+		clientIPResolver = resolver
+	})
+	return clientIPResolver
+}
+
+// ClientResult /geoip/me 的返回结果, 内嵌客户端IP的查询结果
+// 字段名避开 source 等查询结果中已有的字段
+type ClientResult struct {
+	IPQueryResult
+	Header string      `json:"header"` // 确定客户端IP使用的请求头, 未使用转发请求头时为 remote_addr
+	Chain  []HopResult `json:"chain"`  // 转发链, 从客户端到本服务依次排列, 最后一项为直连地址
+}
+
+// HopResult 转发链中的一个地址及其查询结果
+type HopResult struct {
+	IPQueryResult
+	Header  string `json:"header"` // 该地址来自的请求头, 直连地址为 remote_addr
+	Trusted bool   `json:"trusted"`
+}
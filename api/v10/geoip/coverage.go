@@ -0,0 +1,122 @@
+package geoip
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+)
+
+// CIDR 输入的匹配模式
+const (
+	CIDRModeExact       = "exact"       // cidr = ?, 默认
+	CIDRModeContained   = "contained"   // cidr <<= ?, 查询网络包含的子网
+	CIDRModeContaining  = "containing"  // cidr >>= ?, 包含查询网络的父网
+	CIDRModeOverlapping = "overlapping" // cidr && ?, 与查询网络重叠的全部网络
+)
+
+// 非精确匹配时返回的记录数上限, 超出时需要缩小查询范围或使用 /networks 反查
+const maxCIDRMatches = 10000
+
+// Coverage 查询网络被匹配记录覆盖的情况, 地址数使用字符串表示以容纳 IPv6
+type Coverage struct {
+	Addresses string            `json:"addresses"` // 查询网络的地址数
+	Covered   string            `json:"covered"`   // 被至少一条记录覆盖的地址数
+	Fraction  float64           `json:"fraction"`  // covered / addresses
+	Countries []CountryCoverage `json:"countries"` // 按覆盖地址数从多到少排列, 不同国家的记录重叠时分别计算
+}
+
+// CountryCoverage 单个国家覆盖的地址
+type CountryCoverage struct {
+	CountryCode string  `json:"country_code"`
+	Country     string  `json:"country"`
+	Addresses   string  `json:"addresses"`
+	Fraction    float64 `json:"fraction"`
+}
+
+// ParseCIDRMode 校验匹配模式, 为空时使用 exact
+func ParseCIDRMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return CIDRModeExact, nil
+	case CIDRModeExact, CIDRModeContained, CIDRModeContaining, CIDRModeOverlapping:
+		return mode, nil
+	}
+	return "", fmt.Errorf("不支持的匹配模式: %s, 可选 %s", mode,
+		strings.Join([]string{CIDRModeExact, CIDRModeContained, CIDRModeContaining, CIDRModeOverlapping}, ", "))
+}
+
+// cidrOperator 匹配模式对应的 PostgreSQL 网络运算符
+func cidrOperator(mode string) string {
+	switch mode {
+	case CIDRModeContained:
+		return "<<="
+	case CIDRModeContaining:
+		return ">>="
+	case CIDRModeOverlapping:
+		return "&&"
+	}
+	return "="
+}
+
+// computeCoverage 计算 rows 对 block 的覆盖情况
+func computeCoverage(block netip.Prefix, rows []models.GeoIPV10) *Coverage {
+	blockSet := cidrset.New(block)
+	total := blockSet.Size()
+
+	var all []netip.Prefix
+	countries := map[string][]netip.Prefix{}
+	names := map[string]string{}
+	for _, row := range rows {
+		prefix, err := netip.ParsePrefix(row.Cidr)
+		if err != nil {
+			continue
+		}
+		all = append(all, prefix)
+		code := strings.ToUpper(row.CountryCode)
+		countries[code] = append(countries[code], prefix)
+		if names[code] == "" {
+			names[code] = row.Country
+		}
+	}
+
+	covered := cidrset.New(all...).Intersect(blockSet).Size()
+	coverage := &Coverage{
+		Addresses: total.String(),
+		Covered:   covered.String(),
+		Fraction:  fraction(covered, total),
+		Countries: []CountryCoverage{},
+	}
+
+	sizes := map[string]*big.Int{}
+	for code, prefixes := range countries {
+		size := cidrset.New(prefixes...).Intersect(blockSet).Size()
+		sizes[code] = size
+		coverage.Countries = append(coverage.Countries, CountryCoverage{
+			CountryCode: code,
+			Country:     names[code],
+			Addresses:   size.String(),
+			Fraction:    fraction(size, total),
+		})
+	}
+	sort.Slice(coverage.Countries, func(i, j int) bool {
+		a, b := coverage.Countries[i].CountryCode, coverage.Countries[j].CountryCode
+		if c := sizes[a].Cmp(sizes[b]); c != 0 {
+			return c > 0
+		}
+		return a < b
+	})
+	return coverage
+}
+
+func fraction(part, total *big.Int) float64 {
+	if total.Sign() == 0 {
+		return 0
+	}
+	f, _ := new(big.Rat).SetFrac(part, total).Float64()
+	return f
+}
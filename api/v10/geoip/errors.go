@@ -0,0 +1,29 @@
+package geoip
+
+import (
+	"errors"
+)
+
+// 查询错误分类, 具体错误使用 fmt.Errorf("%w: ...") 包装, 通过 errors.Is 判断
+var (
+	ErrInvalidInput    = errors.New("invalid_input")    // 输入不是合法的IP、CIDR或IP范围
+	ErrNotFound        = errors.New("not_found")        // 没有匹配的记录
+	ErrBackend         = errors.New("backend_error")    // 数据库等后端不可用或查询失败
+	ErrReservedAddress = errors.New("reserved_address") // 没有匹配记录的非公网地址, 分类见 ipclass
+)
+
+// 查询结果的状态
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// ErrorCode 返回错误对应的错误码, 未分类的错误视为后端错误
+func ErrorCode(err error) string {
+	for _, sentinel := range []error{ErrInvalidInput, ErrNotFound, ErrReservedAddress, ErrBackend} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return ErrBackend.Error()
+}
@@ -0,0 +1,140 @@
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"gorm.io/gorm"
+)
+
+// NetworkFilter 按字段筛选 geoip_v10 记录, 同一字段的多个值为或关系, 不同字段为且关系
+// 用于导出 CIDR 列表、集合运算和网络反查
+type NetworkFilter struct {
+	CountryCode   []string `json:"country_code"`
+	Province      []string `json:"province"`
+	City          []string `json:"city"`
+	District      []string `json:"district"`
+	ASN           []int    `json:"asn"`
+	ISP           []string `json:"isp"`
+	Source        []string `json:"source"`
+	MinConfidence int      `json:"min_confidence"`
+	Family        int      `json:"family"` // 4 或 6, 0 表示不限
+}
+
+// IsEmpty 是否未指定任何筛选字段, min_confidence 与 family 不单独计入
+func (f NetworkFilter) IsEmpty() bool {
+	return len(f.CountryCode) == 0 && len(f.Province) == 0 && len(f.City) == 0 && len(f.District) == 0 &&
+		len(f.ASN) == 0 && len(f.ISP) == 0 && len(f.Source) == 0
+}
+
+// Apply 将筛选条件应用到 geoip_v10 查询
+func (f NetworkFilter) Apply(tx *gorm.DB) *gorm.DB {
+	if len(f.CountryCode) > 0 {
+		codes := make([]string, 0, len(f.CountryCode))
+		for _, code := range f.CountryCode {
+			codes = append(codes, strings.ToUpper(code))
+		}
+		tx = tx.Where("upper(country_code) IN ?", codes)
+	}
+	columns := []struct {
+		name   string
+		values []string
+	}{
+		{"province", f.Province},
+		{"city", f.City},
+		{"district", f.District},
+		{"isp", f.ISP},
+		{"source", f.Source},
+	}
+	for _, column := range columns {
+		if len(column.values) > 0 {
+			tx = tx.Where(column.name+" IN ?", column.values)
+		}
+	}
+	if len(f.ASN) > 0 {
+		tx = tx.Where("asn IN ?", f.ASN)
+	}
+	if f.MinConfidence > 0 {
+		tx = tx.Where("confidence >= ?", f.MinConfidence)
+	}
+	if f.Family == 4 || f.Family == 6 {
+		tx = tx.Where("family(cidr) = ?", f.Family)
+	}
+	return tx
+}
+
+// Match 记录是否符合筛选条件, 与 Apply 的条件一致
+func (f NetworkFilter) Match(g models.GeoIPV10) bool {
+	if len(f.CountryCode) > 0 && !slices.ContainsFunc(f.CountryCode, func(code string) bool {
+		return strings.EqualFold(code, g.CountryCode)
+	}) {
+		return false
+	}
+	columns := []struct {
+		value  string
+		values []string
+	}{
+		{g.Province, f.Province},
+		{g.City, f.City},
+		{g.District, f.District},
+		{g.ISP, f.ISP},
+		{g.Source, f.Source},
+	}
+	for _, column := range columns {
+		if len(column.values) > 0 && !slices.Contains(column.values, column.value) {
+			return false
+		}
+	}
+	if len(f.ASN) > 0 && !slices.Contains(f.ASN, g.ASN) {
+		return false
+	}
+	if f.MinConfidence > 0 && g.Confidence < f.MinConfidence {
+		return false
+	}
+	if f.Family == 4 || f.Family == 6 {
+		prefix, err := netip.ParsePrefix(g.Cidr)
+		if err != nil || (prefix.Addr().Is4() != (f.Family == 4)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNetworkFilter 解析筛选参数, 多个值可用逗号分隔或重复传参
+func parseNetworkFilter(c *gin.Context) (NetworkFilter, error) {
+	filter := NetworkFilter{
+		CountryCode: queryList(c, "country_code"),
+		Province:    queryList(c, "province"),
+		City:        queryList(c, "city"),
+		District:    queryList(c, "district"),
+		ISP:         queryList(c, "isp"),
+		Source:      queryList(c, "source"),
+	}
+	for _, v := range queryList(c, "asn") {
+		asn, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(v), "AS"))
+		if err != nil {
+			return filter, fmt.Errorf("无效的 asn: %s", v)
+		}
+		filter.ASN = append(filter.ASN, asn)
+	}
+	if v := c.Query("min_confidence"); v != "" {
+		confidence, err := strconv.Atoi(v)
+		if err != nil || confidence < 0 || confidence > 100 {
+			return filter, fmt.Errorf("min_confidence 必须在 0-100 之间: %s", v)
+		}
+		filter.MinConfidence = confidence
+	}
+	if v := c.Query("family"); v != "" {
+		family, err := strconv.Atoi(v)
+		if err != nil || (family != 4 && family != 6) {
+			return filter, fmt.Errorf("family 只能是 4 或 6: %s", v)
+		}
+		filter.Family = family
+	}
+	return filter, nil
+}
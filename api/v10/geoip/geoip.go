@@ -1,30 +1,86 @@
 package geoip
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lwmacct/250300-go-mod-mgin/pkg/mgin"
 	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+	"github.com/lwmacct/250402-m-geoip/internal/clientip"
+	"github.com/lwmacct/250402-m-geoip/internal/ipclass"
 )
 
 // IPQueryResult 查询结果的通用结构，包含查询的IP和结果
 type IPQueryResult struct {
 	models.GeoIPV10
-	Ip string `json:"ip"`
+	Ip         string                 `json:"ip"`
+	Status     string                 `json:"status"`               // ok 或 error
+	Error      string                 `json:"error,omitempty"`      // 错误码: invalid_input, not_found, backend_error, reserved_address
+	Message    string                 `json:"message,omitempty"`    // 错误详情
+	Range      string                 `json:"range,omitempty"`      // 输入为IP范围时的原始输入, ip 为拆分后的网络
+	StartIP    string                 `json:"start_ip,omitempty"`   // range=1 时返回结果网络的起始IP
+	EndIP      string                 `json:"end_ip,omitempty"`     // range=1 时返回结果网络的结束IP
+	Class      string                 `json:"class,omitempty"`      // IANA 特殊用途地址分类, 公网地址为 global
+	ClassName  string                 `json:"class_name,omitempty"` // 特殊用途地址在注册表中的名称
+	Transition *TransitionResult      `json:"transition,omitempty"` // unwrap=1 时 IPv6 过渡地址内嵌 IPv4 地址的查询结果
+	Provenance map[string]FieldSource `json:"provenance,omitempty"`
+	Matches    []Candidate            `json:"matches,omitempty"`  // 非精确匹配模式下的全部记录
+	Coverage   *Coverage              `json:"coverage,omitempty"` // 非精确匹配模式下的覆盖统计
+	Debug      *LookupDebug           `json:"debug,omitempty"`
+}
+
+// TransitionResult IPv6 过渡地址 (ipv4_mapped, 6to4, teredo, nat64) 内嵌的 IPv4 地址及其查询结果
+type TransitionResult struct {
+	Mechanism string         `json:"mechanism"`
+	Ipv4      string         `json:"ipv4"`
+	Server    string         `json:"server,omitempty"` // Teredo 服务器地址
+	Result    *IPQueryResult `json:"result"`
+}
+
+// LookupDebug 调试信息, 包含使用的裁决策略和全部候选记录
+type LookupDebug struct {
+	Policy     string      `json:"policy"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// queryParams 从查询参数解析的查询选项
+type queryParams struct {
+	LookupOptions
+	Debug   bool
+	Range   bool            // 结果同时返回 start_ip 与 end_ip
+	Unwrap  bool            // 同时查询 IPv6 过渡地址内嵌的 IPv4 地址
+	Classes map[string]bool // 批量查询只返回这些分类的地址, 为空时不筛选
 }
 
 type main struct {
 	mgin.Handler
-	srv1 *SrvDBQuery
+	srv1  *SrvDBQuery
+	srv2  *SrvDBWrite
+	srv3  *SrvDBExport
+	srv4  *SrvDBSearch
+	proxy *clientip.Resolver
 }
 
 func (t *main) Register(r *gin.RouterGroup) {
 	rg := r.Group("geoip")
 	rg.GET("", t.Get)
+	rg.GET("export", t.Export)
+	rg.GET("networks", t.Networks)
+	rg.GET("me", t.Me)
 	rg.GET(":ip", t.Get)
 	rg.POST("", t.Post)
+	rg.POST("stream", t.Stream)
 	rg.PUT("", t.Put)
 	rg.DELETE("", t.Delete)
 }
@@ -33,59 +89,88 @@ func (t *main) Get(c *gin.Context) {
 	// 从路径参数中获取IP地址或CIDR
 	input := c.Param("ip")
 
+	params, err := parseQueryParams(c)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
 	// 准备返回结果数组
 	var results []IPQueryResult
 
 	// 检查是否提供了多个IP (以逗号分隔)
 	if input != "" && strings.Contains(input, ",") {
 		// 批量查询处理
-		ips := strings.Split(input, ",")
-
-		// 处理每个IP
-		for _, ip := range ips {
+		var ips []string
+		for _, ip := range strings.Split(input, ",") {
 			ip = strings.TrimSpace(ip) // 移除可能的空格
 			if ip == "" {
 				continue
 			}
-
-			result := IPQueryResult{Ip: ip}
-			// result := IPQueryResult[models.GeoIPV10]{IP: ip}
-			ipData, err := t.srv1.GetIPInfo(ip)
-
-			if err == nil {
-				result.GeoIPV10 = ipData
-			}
-
-			results = append(results, result)
+			ips = append(ips, ip)
 		}
+		results = t.queryBatch(ips, params, nil)
 	} else {
 		// 单个IP查询处理
 		if input == "" {
-			input = c.ClientIP() // 如果没有提供输入，使用客户端IP
+			input = t.clientIP(c) // 如果没有提供输入，使用客户端IP
 		}
 
-		// result := IPQueryResult[models.GeoIPV10]{IP: input}
-		result := IPQueryResult{Ip: input}
-		ipData, err := t.srv1.GetIPInfo(input)
+		results = append(results, t.query(input, params)...)
+	}
 
-		if err == nil {
-			result.GeoIPV10 = ipData
-		}
+	// 返回结果数组
+	t.respond(c, results)
+}
 
-		results = append(results, result)
+// Me 返回请求方的客户端IP及完整的转发链, 转发链中的每个地址都查询地理位置
+func (t *main) Me(c *gin.Context) {
+	params, err := parseQueryParams(c)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
 	}
+	params.Classes = nil
 
-	// 返回结果数组
-	response := mgin.Response[[]IPQueryResult]{
+	resolved := t.proxy.Resolve(c.Request)
+	inputs := make([]string, 0, len(resolved.Chain))
+	for _, hop := range resolved.Chain {
+		inputs = append(inputs, hop.Addr.String())
+	}
+	results := t.queryBatch(inputs, params, nil)
+
+	data := ClientResult{Header: resolved.Source, Chain: make([]HopResult, 0, len(results))}
+	for i, hop := range resolved.Chain {
+		data.Chain = append(data.Chain, HopResult{IPQueryResult: results[i], Header: hop.Source, Trusted: hop.Trusted})
+		if hop.Addr == resolved.Client {
+			data.IPQueryResult = results[i]
+		}
+	}
+
+	response := mgin.Response[ClientResult]{
 		Code: http.StatusOK,
 		Msg:  "success",
-		Data: results,
+		Data: data,
 	}
 	c.JSON(response.Code, response)
 }
 
+// clientIP 按可信代理配置解析客户端IP
+func (t *main) clientIP(c *gin.Context) string {
+	if addr := t.proxy.Resolve(c.Request).Client; addr.IsValid() {
+		return addr.String()
+	}
+	return c.ClientIP()
+}
+
 // Post 处理批量IP查询请求
 func (t *main) Post(c *gin.Context) {
+	params, err := parseQueryParams(c)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
 	// 直接使用IP地址数组作为请求
 	var ips []string
 
@@ -111,50 +196,460 @@ func (t *main) Post(c *gin.Context) {
 		return
 	}
 
-	// 存储查询结果
-	var results []IPQueryResult
-
-	// 验证并处理每个IP
+	// 移除空格和空项, 基本格式验证 - 简单检查是否包含字母数字和常见IP符号
+	var inputs []string
 	for _, ip := range ips {
-		// 移除空格
-		ip = strings.TrimSpace(ip)
+		if ip = strings.TrimSpace(ip); ip != "" {
+			inputs = append(inputs, ip)
+		}
+	}
+	results := t.queryBatch(inputs, params, isValidIPFormat)
 
-		// 验证IP非空
-		if ip == "" {
-			continue
+	// 返回查询结果
+	t.respond(c, results)
+}
+
+// respond 返回查询结果, 全部条目均为后端错误时返回 503, 部分后端错误时在 msg 中说明
+func (t *main) respond(c *gin.Context, results []IPQueryResult) {
+	failed := 0
+	for _, r := range results {
+		if r.Error == ErrBackend.Error() {
+			failed++
 		}
+	}
 
-		// 基本格式验证 - 简单检查是否包含字母数字和常见IP符号
-		if !isValidIPFormat(ip) {
-			result := IPQueryResult{Ip: ip}
-			results = append(results, result)
-			continue
+	response := mgin.Response[[]IPQueryResult]{
+		Code: http.StatusOK,
+		Msg:  "success",
+		Data: results,
+	}
+	switch {
+	case failed > 0 && failed == len(results):
+		response.Code, response.Msg = http.StatusServiceUnavailable, "后端服务不可用"
+	case failed > 0:
+		response.Msg = fmt.Sprintf("%d/%d 条后端错误", failed, len(results))
+	}
+	c.JSON(response.Code, response)
+}
+
+// 流式查询的行长度上限与输出缓冲区大小
+const (
+	streamMaxLine    = 64 << 10
+	streamBufferSize = 32 << 10
+)
+
+// StreamError 流式查询中无法处理的行, 以及导致提前结束的错误
+type StreamError struct {
+	Line    int    `json:"line"`
+	Status  string `json:"status"`
+	Error   string `json:"error"` // 错误码: invalid_input, limit_exceeded, backend_error
+	Message string `json:"message"`
+}
+
+// streamError 创建流式查询的错误行
+func streamError(line int, code, message string) StreamError {
+	return StreamError{Line: line, Status: StatusError, Error: code, Message: message}
+}
+
+// Stream 流式批量查询, 请求体每行一个IP/CIDR/IP范围, 或 {"ip": "..."} 对象, 或 JSON 字符串
+// 结果按输入顺序逐行输出 NDJSON; 逐行读取并同步写出, 客户端不读取结果时写入阻塞, 同时停止读取请求体
+func (t *main) Stream(c *gin.Context) {
+	params, err := parseQueryParams(c)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
+	limit := app.Flag.App.Stream.MaxItems
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			t.Return400(c, "无效的 limit: "+v)
+			return
+		}
+		if limit <= 0 || n < limit {
+			limit = n
+		}
+	}
+
+	// HTTP/1.1 默认在开始写响应后不再允许读取请求体
+	_ = http.NewResponseController(c.Writer).EnableFullDuplex()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	reader := bufio.NewReaderSize(c.Request.Body, streamMaxLine)
+	writer := bufio.NewWriterSize(c.Writer, streamBufferSize)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	flush := func() bool {
+		if writer.Flush() != nil {
+			return false
+		}
+		c.Writer.Flush()
+		return true
+	}
+
+	items, lineNo := 0, 0
+	for c.Request.Context().Err() == nil {
+		// 请求体已读取的数据处理完后再输出, 下一次读取可能阻塞
+		if reader.Buffered() == 0 && !flush() {
+			return
+		}
+
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			encoder.Encode(streamError(lineNo+1, ErrInvalidInput.Error(), fmt.Sprintf("单行超过 %d 字节", streamMaxLine)))
+			break
+		}
+		if len(line) > 0 {
+			lineNo++
+			input, perr := parseStreamLine(line)
+			switch {
+			case perr != nil:
+				encoder.Encode(streamError(lineNo, ErrInvalidInput.Error(), perr.Error()))
+			case input != "":
+				if items++; limit > 0 && items > limit {
+					encoder.Encode(streamError(lineNo, "limit_exceeded", fmt.Sprintf("超过单次请求的条数上限 %d", limit)))
+					flush()
+					return
+				}
+				for _, result := range t.query(input, params) {
+					if encoder.Encode(result) != nil {
+						return
+					}
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				encoder.Encode(streamError(lineNo, ErrBackend.Error(), "读取请求体失败: "+err.Error()))
+			}
+			break
 		}
+	}
+	flush()
+}
 
-		result := IPQueryResult{Ip: ip}
-		ipData, err := t.srv1.GetIPInfo(ip)
+// parseStreamLine 解析流式查询的一行, 空行返回空字符串
+func parseStreamLine(line []byte) (string, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return "", nil
+	}
 
-		if err == nil {
-			result.GeoIPV10 = ipData
+	switch line[0] {
+	case '{':
+		var item struct {
+			Ip string `json:"ip"`
+		}
+		if err := json.Unmarshal(line, &item); err != nil {
+			return "", fmt.Errorf("无效的 JSON 对象: %v", err)
+		}
+		if item.Ip = strings.TrimSpace(item.Ip); item.Ip == "" {
+			return "", fmt.Errorf("缺少 ip 字段")
 		}
+		return item.Ip, nil
+	case '"':
+		var ip string
+		if err := json.Unmarshal(line, &ip); err != nil {
+			return "", fmt.Errorf("无效的 JSON 字符串: %v", err)
+		}
+		return strings.TrimSpace(ip), nil
+	}
+	return string(line), nil
+}
 
+// query 查询单个IP、CIDR或IP范围, IP范围拆分为最少的 CIDR 后逐个查询
+func (t *main) query(input string, params queryParams) []IPQueryResult {
+	return t.queryBatch([]string{input}, params, nil)
+}
+
+// queryBatch 批量查询, 相同的输入只查询一次, 结果保持输入顺序
+// valid 不为 nil 时, 未通过校验的输入不查询, 只返回错误; 指定了 class 时不属于这些分类的输入不查询也不返回
+func (t *main) queryBatch(inputs []string, params queryParams, valid func(string) bool) []IPQueryResult {
+	type item struct {
+		input string
+		rng   string
+		err   error // 不为 nil 时不查询
+	}
+	items := make([]item, 0, len(inputs))
+	for _, input := range inputs {
+		switch {
+		case valid != nil && !valid(input):
+			items = append(items, item{input: input, err: fmt.Errorf("%w: 无效的输入格式: %s", ErrInvalidInput, input)})
+		case IsRange(input):
+			prefixes, err := RangePrefixes(input)
+			if err != nil {
+				items = append(items, item{input: input, rng: input, err: err})
+				continue
+			}
+			for _, prefix := range prefixes {
+				if params.accept(prefix.String()) {
+					items = append(items, item{input: prefix.String(), rng: input})
+				}
+			}
+		case params.accept(input):
+			items = append(items, item{input: input})
+		}
+	}
+
+	// unwrap=1 时过渡地址内嵌的 IPv4 地址紧跟在原地址之后一起查询
+	var lookups []string
+	transitions := map[int]ipclass.Transition{}
+	for i, it := range items {
+		if it.err != nil {
+			continue
+		}
+		lookups = append(lookups, it.input)
+		if !params.Unwrap {
+			continue
+		}
+		if addr, err := netip.ParseAddr(it.input); err == nil {
+			if tr, ok := ipclass.Unwrap(addr); ok {
+				transitions[i] = tr
+				lookups = append(lookups, tr.IPv4.String())
+			}
+		}
+	}
+	found, errs := t.srv1.LookupBatch(lookups, params.LookupOptions)
+
+	results := make([]IPQueryResult, 0, len(items))
+	for i, it := range items {
+		var result IPQueryResult
+		if it.err != nil {
+			result = t.result(it.input, LookupResult{}, it.err, queryParams{})
+		} else {
+			result = t.result(it.input, found[0], errs[0], params)
+			found, errs = found[1:], errs[1:]
+		}
+		if tr, ok := transitions[i]; ok {
+			embedded := t.result(tr.IPv4.String(), found[0], errs[0], params)
+			found, errs = found[1:], errs[1:]
+			result.Transition = &TransitionResult{Mechanism: tr.Mechanism, Ipv4: tr.IPv4.String(), Result: &embedded}
+			if tr.Server.IsValid() {
+				result.Transition.Server = tr.Server.String()
+			}
+		}
+		result.Range = it.rng
 		results = append(results, result)
 	}
+	return results
+}
 
-	// 返回查询结果
-	response := mgin.Response[[]IPQueryResult]{
+// result 将查询结果转换为接口返回的结构
+func (t *main) result(input string, lookup LookupResult, err error, params queryParams) IPQueryResult {
+	result := IPQueryResult{Ip: input, Status: StatusOK}
+	if info, ok := classifyInput(input); ok {
+		result.Class, result.ClassName = info.Class, info.Name
+	}
+	if err != nil {
+		result.Status, result.Error = StatusError, ErrorCode(err)
+		result.Message = strings.TrimPrefix(err.Error(), result.Error+": ")
+	} else {
+		result.GeoIPV10 = lookup.Record
+		result.Provenance = lookup.Provenance
+		if lookup.Coverage != nil {
+			result.Matches = lookup.Candidates
+			result.Coverage = lookup.Coverage
+		}
+		if prefix, err := netip.ParsePrefix(result.Cidr); err == nil && params.Range {
+			r := cidrset.PrefixRange(prefix)
+			result.StartIP, result.EndIP = r.From.String(), r.To.String()
+		}
+	}
+	if params.Debug {
+		result.Debug = &LookupDebug{Policy: lookup.Policy, Candidates: lookup.Candidates}
+	}
+	return result
+}
+
+// parseQueryParams 解析 policy、merge、mode、range、unwrap、internal、class、debug 查询参数
+func parseQueryParams(c *gin.Context) (queryParams, error) {
+	params := queryParams{}
+	policy, err := DefaultPolicy().WithName(c.Query("policy"))
+	if err != nil {
+		return params, err
+	}
+	params.Policy = policy
+	params.Merge = isTrue(c.Query("merge"))
+	if params.Mode, err = ParseCIDRMode(c.Query("mode")); err != nil {
+		return params, err
+	}
+	params.Debug = isTrue(c.Query("debug"))
+	params.Range = isTrue(c.Query("range"))
+	params.Unwrap = isTrue(c.Query("unwrap"))
+	params.Internal = isTrue(c.Query("internal"))
+	for _, class := range queryList(c, "class") {
+		if !ipclass.Valid(class) {
+			return params, fmt.Errorf("无效的地址分类: %s, 可选 %s", class, strings.Join(ipclass.Classes, ", "))
+		}
+		if params.Classes == nil {
+			params.Classes = map[string]bool{}
+		}
+		params.Classes[class] = true
+	}
+	return params, nil
+}
+
+// accept 判断输入是否属于 class 参数指定的分类, 无法分类的输入交由查询返回错误
+func (p queryParams) accept(input string) bool {
+	if len(p.Classes) == 0 {
+		return true
+	}
+	info, ok := classifyInput(input)
+	return !ok || p.Classes[info.Class]
+}
+
+// classifyInput 对IP或CIDR输入分类
+func classifyInput(input string) (ipclass.Info, bool) {
+	if addr, err := netip.ParseAddr(input); err == nil {
+		return ipclass.Classify(addr), true
+	}
+	if prefix, err := netip.ParsePrefix(input); err == nil {
+		return ipclass.ClassifyPrefix(prefix), true
+	}
+	return ipclass.Info{}, false
+}
+
+// isTrue 判断查询参数是否为真
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// Put 按 (source, cidr) 插入或更新记录, 请求体为单个对象或对象数组
+func (t *main) Put(c *gin.Context) {
+	t.write(c, t.srv2.Upsert)
+}
+
+// Delete 按 (source, cidr) 删除记录, 请求体为单个对象或对象数组
+func (t *main) Delete(c *gin.Context) {
+	t.write(c, t.srv2.Delete)
+}
+
+// write 执行写入操作并返回逐条结果
+func (t *main) write(c *gin.Context, fn func([]byte) ([]WriteResult, error)) {
+	body, err := c.GetRawData()
+	if err != nil {
+		t.Return400(c, "读取请求体失败: "+err.Error())
+		return
+	}
+
+	results, err := fn(body)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Failed() {
+			failed++
+		}
+	}
+
+	response := mgin.Response[[]WriteResult]{
 		Code: http.StatusOK,
 		Msg:  "success",
 		Data: results,
 	}
+	if failed > 0 {
+		response.Msg = fmt.Sprintf("%d/%d 条失败", failed, len(results))
+	}
+	c.JSON(response.Code, response)
+}
+
+// Export 按条件导出聚合后的 CIDR 列表, format 可选 plain、nginx、ipset、nftables、bind、range
+func (t *main) Export(c *gin.Context) {
+	format := c.DefaultQuery("format", ExportFormatPlain)
+	name := c.DefaultQuery("name", "geoip")
+	if err := ValidExportFormat(format, name); err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
+	filter, err := parseNetworkFilter(c)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
+	prefixes, err := t.srv3.List(filter)
+	if err != nil {
+		t.returnError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := t.srv3.Render(c.Writer, format, name, prefixes); err != nil {
+		c.Error(err)
+	}
+}
+
+// Networks 按地理位置、ASN 等字段反查网络, 使用 cursor 分页
+func (t *main) Networks(c *gin.Context) {
+	filter, err := parseNetworkFilter(c)
+	if err != nil {
+		t.Return400(c, err.Error())
+		return
+	}
+
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+	if err != nil {
+		t.Return400(c, "无效的 cursor: "+c.Query("cursor"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		t.Return400(c, "无效的 limit: "+c.Query("limit"))
+		return
+	}
+
+	result, err := t.srv4.Search(filter, uint(cursor), limit)
+	if err != nil {
+		t.returnError(c, err)
+		return
+	}
+
+	response := mgin.Response[SearchResult]{
+		Code: http.StatusOK,
+		Msg:  "success",
+		Data: result,
+	}
 	c.JSON(response.Code, response)
 }
 
+// returnError 后端错误返回 503, 其余视为请求参数错误返回 400
+func (t *main) returnError(c *gin.Context, err error) {
+	if errors.Is(err, ErrBackend) {
+		t.Return503(c, err.Error())
+		return
+	}
+	t.Return400(c, err.Error())
+}
+
+// queryList 读取可重复且可逗号分隔的查询参数
+func queryList(c *gin.Context, key string) []string {
+	var list []string
+	for _, value := range c.QueryArray(key) {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
 // isValidIPFormat 简单验证IP格式是否合法
 func isValidIPFormat(ip string) bool {
-	// 验证IP字符串只包含合法的IP字符：数字、点、冒号（IPv6）、斜杠（CIDR表示法）
+	// 验证IP字符串只包含合法的IP字符：数字、十六进制字母和冒号（IPv6）、点、斜杠（CIDR表示法）、连字符（IP范围）
 	for _, c := range ip {
-		if !((c >= '0' && c <= '9') || c == '.' || c == ':' || c == '/' || c == '-') {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || c == '.' || c == ':' || c == '/' || c == '-') {
 			return false
 		}
 	}
@@ -166,5 +661,9 @@ func New(router *gin.RouterGroup) *main {
 	t.Register(router)
 	// 确保服务已初始化
 	t.srv1 = new(SrvDBQuery).Init()
+	t.srv2 = new(SrvDBWrite).Init()
+	t.srv3 = new(SrvDBExport).Init()
+	t.srv4 = new(SrvDBSearch).Init()
+	t.proxy = ClientIPResolver()
 	return t
 }
@@ -0,0 +1,142 @@
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+)
+
+// 重叠网络的裁决策略
+const (
+	PolicyMostSpecific   = "most_specific"   // 最长前缀优先, 其次可信度
+	PolicyConfidence     = "confidence"      // 可信度优先, 其次最长前缀
+	PolicySourcePriority = "source_priority" // 按来源优先级列表, 其次最长前缀
+	PolicyWeighted       = "weighted"        // 前缀长度、可信度、来源优先级加权打分
+)
+
+// Policy 多条记录同时命中时的裁决策略
+type Policy struct {
+	Name             string
+	Sources          []string // 来源优先级, 越靠前越优先, 未列出的来源排在最后
+	WeightPrefix     float64
+	WeightConfidence float64
+	WeightSource     float64
+}
+
+// Candidate 参与裁决的候选记录
+type Candidate struct {
+	models.GeoIPV10
+	Bits  int     `json:"bits"`
+	Score float64 `json:"score"`
+}
+
+// DefaultPolicy 返回配置中的默认策略
+func DefaultPolicy() Policy {
+	cfg := app.Flag.App.Policy
+	return Policy{
+		Name:             cfg.Name,
+		Sources:          cfg.Sources,
+		WeightPrefix:     cfg.WeightPrefix,
+		WeightConfidence: cfg.WeightConfidence,
+		WeightSource:     cfg.WeightSource,
+	}
+}
+
+// WithName 返回使用指定策略名称的副本, name 为空时保持不变
+func (p Policy) WithName(name string) (Policy, error) {
+	if name == "" {
+		name = p.Name
+	}
+	switch name {
+	case "":
+		name = PolicyMostSpecific
+	case PolicyMostSpecific, PolicyConfidence, PolicySourcePriority, PolicyWeighted:
+	default:
+		return p, fmt.Errorf("不支持的裁决策略: %s, 可选 %s", name,
+			strings.Join([]string{PolicyMostSpecific, PolicyConfidence, PolicySourcePriority, PolicyWeighted}, ", "))
+	}
+	p.Name = name
+	return p, nil
+}
+
+// Rank 对候选记录打分并按策略排序, 第一条为最终结果
+func (p Policy) Rank(rows []models.GeoIPV10) []Candidate {
+	candidates := make([]Candidate, 0, len(rows))
+	for _, row := range rows {
+		c := Candidate{GeoIPV10: row}
+		if prefix, err := netip.ParsePrefix(row.Cidr); err == nil {
+			c.Bits = prefix.Bits()
+		}
+		c.Score = p.score(c)
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return p.less(candidates[i], candidates[j])
+	})
+	return candidates
+}
+
+// less 判断 a 是否应排在 b 之前
+func (p Policy) less(a, b Candidate) bool {
+	type key struct{ a, b float64 }
+	var keys []key
+
+	bits := key{float64(a.Bits), float64(b.Bits)}
+	confidence := key{float64(a.Confidence), float64(b.Confidence)}
+	source := key{-float64(p.sourceRank(a.Source)), -float64(p.sourceRank(b.Source))}
+
+	switch p.Name {
+	case PolicyConfidence:
+		keys = []key{confidence, bits, source}
+	case PolicySourcePriority:
+		keys = []key{source, bits, confidence}
+	case PolicyWeighted:
+		keys = []key{{a.Score, b.Score}, bits, confidence}
+	default:
+		keys = []key{bits, confidence, source}
+	}
+
+	for _, k := range keys {
+		if k.a != k.b {
+			return k.a > k.b
+		}
+	}
+	return a.ID < b.ID
+}
+
+// score 加权分数, 各项归一化到 0-100
+func (p Policy) score(c Candidate) float64 {
+	if p.Name != PolicyWeighted {
+		return 0
+	}
+
+	maxBits := 32.0
+	if prefix, err := netip.ParsePrefix(c.Cidr); err == nil && prefix.Addr().Is6() {
+		maxBits = 128.0
+	}
+
+	sourceScore := 0.0
+	if n := len(p.Sources); n > 0 {
+		if rank := p.sourceRank(c.Source); rank < n {
+			sourceScore = float64(n-rank) / float64(n) * 100
+		}
+	}
+
+	return p.WeightPrefix*float64(c.Bits)/maxBits*100 +
+		p.WeightConfidence*float64(c.Confidence) +
+		p.WeightSource*sourceScore
+}
+
+// sourceRank 来源在优先级列表中的位置, 未列出时返回列表长度
+func (p Policy) sourceRank(source string) int {
+	if i := slices.Index(p.Sources, source); i >= 0 {
+		return i
+	}
+	return len(p.Sources)
+}
@@ -0,0 +1,118 @@
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+)
+
+// 批量预查询时每条 SQL 包含的IP数量
+const prefetchChunk = 1000
+
+// LookupBatch 批量查询, 相同的输入只查询一次, 结果与 inputs 按位置一一对应
+// 前缀树未加载时先用一条 SQL 批量取回全部IP输入的候选记录, 之后按 --app-bulk-workers 并发裁决
+func (t *SrvDBQuery) LookupBatch(inputs []string, opt LookupOptions) ([]LookupResult, []error) {
+	index := map[string]int{}
+	var unique []string
+	for _, input := range inputs {
+		if _, ok := index[input]; !ok {
+			index[input] = len(unique)
+			unique = append(unique, input)
+		}
+	}
+
+	var prefetched map[string][]models.GeoIPV10
+	if !t.trie.Ready() && app.DB != nil {
+		var err error
+		if prefetched, err = t.prefetchIPs(unique); err != nil {
+			// 批量查询失败时退回逐条查询, 由逐条查询返回各自的错误
+			mlog.Error(mlog.H{"msg": "批量预查询失败", "count": len(unique), "err": err.Error()})
+			prefetched = nil
+		}
+	}
+
+	results := make([]LookupResult, len(unique))
+	errs := make([]error, len(unique))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(app.Flag.App.Bulk.Workers, 1), len(unique)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = t.lookup(unique[i], opt, prefetched)
+			}
+		}()
+	}
+	for i := range unique {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	outResults := make([]LookupResult, len(inputs))
+	outErrs := make([]error, len(inputs))
+	for i, input := range inputs {
+		outResults[i], outErrs[i] = results[index[input]], errs[index[input]]
+	}
+	return outResults, outErrs
+}
+
+// prefetchIPs 使用集合查询取回所有IP输入的候选记录, 非IP输入 (CIDR、范围) 不在结果中
+// 没有匹配记录的IP也会出现在结果中, 值为空; 结果按原始输入索引
+func (t *SrvDBQuery) prefetchIPs(inputs []string) (map[string][]models.GeoIPV10, error) {
+	ips, keys := prefetchAddrs(inputs)
+
+	prefetched := make(map[string][]models.GeoIPV10, len(inputs))
+	for start := 0; start < len(ips); start += prefetchChunk {
+		chunk := ips[start:min(start+prefetchChunk, len(ips))]
+		var rows []struct {
+			QueryIP string
+			models.GeoIPV10
+		}
+		err := app.DB.Raw(fmt.Sprintf(
+			"SELECT q.ip AS query_ip, g.* FROM unnest(string_to_array(?, ',')) AS q(ip) JOIN %s g ON g.cidr >>= q.ip::inet WHERE g.deleted_at IS NULL",
+			models.GeoIPV10{}.TableName(),
+		), strings.Join(chunk, ",")).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ip := range chunk {
+			for _, input := range keys[ip] {
+				prefetched[input] = nil
+			}
+		}
+		for _, row := range rows {
+			for _, input := range keys[row.QueryIP] {
+				prefetched[input] = append(prefetched[input], row.GeoIPV10)
+			}
+		}
+	}
+	mlog.Info(mlog.H{"msg": "数据库批量IP查询成功", "ips": len(ips)})
+	return prefetched, nil
+}
+
+// prefetchAddrs 返回去重后的规范化地址及其对应的原始输入
+// IPv4-mapped 地址按 IPv4 查询, 与单条查询和前缀树一致
+func prefetchAddrs(inputs []string) ([]string, map[string][]string) {
+	var ips []string
+	keys := map[string][]string{}
+	for _, input := range inputs {
+		addr, err := netip.ParseAddr(input)
+		if err != nil || addr.Zone() != "" {
+			continue
+		}
+		ip := addr.Unmap().String()
+		if _, ok := keys[ip]; !ok {
+			ips = append(ips, ip)
+		}
+		keys[ip] = append(keys[ip], input)
+	}
+	return ips, keys
+}
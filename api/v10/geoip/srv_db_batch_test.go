@@ -0,0 +1,26 @@
+package geoip
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestPrefetchAddrs(t *testing.T) {
+	ips, keys := prefetchAddrs([]string{
+		"1.2.3.4", "::ffff:1.2.3.4", "::FFFF:1.2.3.4", "2001:DB8::1", "2001:db8::1",
+		"1.2.3.0/24", "1.2.3.4-1.2.3.5", "fe80::1%eth0", "example.com",
+	})
+
+	if want := []string{"1.2.3.4", "2001:db8::1"}; !slices.Equal(ips, want) {
+		t.Errorf("ips = %v, want %v", ips, want)
+	}
+	want := map[string][]string{
+		// IPv4-mapped 地址与 IPv4 地址查询同一网络, 结果按原始输入返回
+		"1.2.3.4":     {"1.2.3.4", "::ffff:1.2.3.4", "::FFFF:1.2.3.4"},
+		"2001:db8::1": {"2001:DB8::1", "2001:db8::1"},
+	}
+	if !maps.EqualFunc(keys, want, slices.Equal) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+}
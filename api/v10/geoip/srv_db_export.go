@@ -0,0 +1,204 @@
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+	"gorm.io/gorm"
+)
+
+// CIDR 列表的输出格式
+const (
+	ExportFormatPlain    = "plain"    // 每行一个 CIDR
+	ExportFormatNginx    = "nginx"    // nginx geo {} 块
+	ExportFormatIpset    = "ipset"    // ipset restore 脚本
+	ExportFormatNftables = "nftables" // nftables 集合
+	ExportFormatBind     = "bind"     // BIND acl 语句
+	ExportFormatRange    = "range"    // 每行一个 起始IP-结束IP 范围, 相邻的网络合并为一个范围
+)
+
+// 集合名称只允许字母数字、下划线和连字符, ipset 限制最长 31 个字符
+var exportNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,27}$`)
+
+// SrvDBExport 按条件导出聚合后的 CIDR 列表
+type SrvDBExport struct {
+	once sync.Once
+	trie *SrvTrie
+}
+
+// Init 初始化服务
+func (t *SrvDBExport) Init() *SrvDBExport {
+	t.once.Do(func() {
+		// 不启动后台加载, 前缀树未加载时 (如命令行导出) 只从数据库加载与筛选结果相关的网络
+		t.trie = srvTrie
+	})
+	return t
+}
+
+// List 返回查询结果符合条件的全部地址, 合并为最少的 CIDR
+//
+// 每个网络按裁决策略选出一条记录 (与 /geoip/:ip 和 mmdb 导出一致), 筛选条件作用于选出的记录;
+// 网络内被更具体的网络覆盖的部分由更具体的网络决定, 如 CN 的 /16 内 HK 的 /24 不会出现在 CN 的列表中
+func (t *SrvDBExport) List(filter NetworkFilter) ([]netip.Prefix, error) {
+	if filter.IsEmpty() {
+		return nil, fmt.Errorf("至少需要一个筛选条件: country_code, province, city, district, asn, isp, source")
+	}
+	policy, err := DefaultPolicy().WithName("")
+	if err != nil {
+		return nil, err
+	}
+	tree := t.trie.Snapshot()
+	if tree == nil {
+		if app.DB == nil {
+			return nil, fmt.Errorf("%w: 数据库连接未初始化", ErrBackend)
+		}
+		if tree, _, err = loadTree(overlapping(filter)); err != nil {
+			mlog.Error(mlog.H{"msg": "导出查询失败", "filter": filter, "err": err.Error()})
+			return nil, fmt.Errorf("%w: 数据库查询错误: %v", ErrBackend, err)
+		}
+	}
+
+	var matched int
+	var prefixes []netip.Prefix
+	tree.Walk(func(p netip.Prefix, _ []models.GeoIPV10) bool {
+		if !filter.Match(resolveNetwork(tree, p, policy)) {
+			return true
+		}
+		matched++
+		var inner []netip.Prefix
+		for _, entry := range tree.Subnets(p) {
+			if entry.Prefix != p {
+				inner = append(inner, entry.Prefix)
+			}
+		}
+		if len(inner) == 0 {
+			prefixes = append(prefixes, p)
+			return true
+		}
+		prefixes = append(prefixes, cidrset.New(p).Subtract(cidrset.New(inner...)).Prefixes()...)
+		return true
+	})
+
+	aggregated := cidrset.Aggregate(prefixes)
+	mlog.Info(mlog.H{"msg": "导出CIDR列表", "filter": filter, "policy": policy.Name, "networks": matched, "prefixes": len(aggregated)})
+	return aggregated, nil
+}
+
+// overlapping 查询与符合筛选条件的记录重叠的全部记录
+//
+// 符合条件的网络与其超网、子网互相重叠, 裁决和扣除子网所需的记录都在结果中, 无需加载整张表
+func overlapping(filter NetworkFilter) *gorm.DB {
+	name := models.GeoIPV10{}.TableName()
+	matched := filter.Apply(app.DB.Table(name + " AS c")).Select("1").
+		Where("c.deleted_at IS NULL AND c.cidr && " + name + ".cidr")
+	return app.DB.Model(&models.GeoIPV10{}).Where("EXISTS (?)", matched)
+}
+
+// ValidExportFormat 校验输出格式和集合名称
+func ValidExportFormat(format, name string) error {
+	switch format {
+	case ExportFormatPlain, ExportFormatNginx, ExportFormatIpset, ExportFormatNftables, ExportFormatBind, ExportFormatRange:
+	default:
+		return fmt.Errorf("不支持的输出格式: %s, 可选 %s", format, strings.Join([]string{
+			ExportFormatPlain, ExportFormatNginx, ExportFormatIpset, ExportFormatNftables, ExportFormatBind, ExportFormatRange,
+		}, ", "))
+	}
+	if !exportNamePattern.MatchString(name) {
+		return fmt.Errorf("无效的名称: %q, 需以字母开头, 只包含字母数字、下划线和连字符, 最长 28 个字符", name)
+	}
+	return nil
+}
+
+// Render 按格式输出 CIDR 列表, name 用作 nginx 变量、ipset/nftables 集合或 BIND acl 的名称
+// ipset 与 nftables 的 IPv6 集合名称追加 6 或 _v6 后缀
+func (t *SrvDBExport) Render(out io.Writer, format, name string, prefixes []netip.Prefix) error {
+	if err := ValidExportFormat(format, name); err != nil {
+		return err
+	}
+
+	var v4, v6 []netip.Prefix
+	for _, p := range prefixes {
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	switch format {
+	case ExportFormatPlain:
+		for _, p := range prefixes {
+			fmt.Fprintln(w, p)
+		}
+
+	case ExportFormatRange:
+		for _, r := range cidrset.New(prefixes...).Ranges() {
+			fmt.Fprintln(w, r)
+		}
+
+	case ExportFormatNginx:
+		fmt.Fprintf(w, "geo $%s {\n", name)
+		fmt.Fprintf(w, "    default 0;\n")
+		for _, p := range prefixes {
+			fmt.Fprintf(w, "    %s 1;\n", p)
+		}
+		fmt.Fprintf(w, "}\n")
+
+	case ExportFormatIpset:
+		for _, set := range []struct {
+			name     string
+			family   string
+			prefixes []netip.Prefix
+		}{{name, "inet", v4}, {name + "6", "inet6", v6}} {
+			fmt.Fprintf(w, "create %s hash:net family %s maxelem %d -exist\n", set.name, set.family, max(65536, len(set.prefixes)))
+			fmt.Fprintf(w, "flush %s\n", set.name)
+			for _, p := range set.prefixes {
+				fmt.Fprintf(w, "add %s %s -exist\n", set.name, p)
+			}
+		}
+
+	case ExportFormatNftables:
+		fmt.Fprintf(w, "table inet geoip {\n")
+		for _, set := range []struct {
+			name     string
+			typ      string
+			prefixes []netip.Prefix
+		}{{name + "_v4", "ipv4_addr", v4}, {name + "_v6", "ipv6_addr", v6}} {
+			fmt.Fprintf(w, "    set %s {\n", set.name)
+			fmt.Fprintf(w, "        type %s\n", set.typ)
+			fmt.Fprintf(w, "        flags interval\n")
+			if len(set.prefixes) > 0 {
+				fmt.Fprintf(w, "        elements = {\n")
+				for i, p := range set.prefixes {
+					sep := ","
+					if i == len(set.prefixes)-1 {
+						sep = ""
+					}
+					fmt.Fprintf(w, "            %s%s\n", p, sep)
+				}
+				fmt.Fprintf(w, "        }\n")
+			}
+			fmt.Fprintf(w, "    }\n")
+		}
+		fmt.Fprintf(w, "}\n")
+
+	case ExportFormatBind:
+		fmt.Fprintf(w, "acl %s {\n", strconv.Quote(name))
+		for _, p := range prefixes {
+			fmt.Fprintf(w, "    %s;\n", p)
+		}
+		fmt.Fprintf(w, "};\n")
+	}
+	return w.Flush()
+}
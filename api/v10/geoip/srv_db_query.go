@@ -1,87 +1,351 @@
 package geoip
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"strings"
 	"sync"
 
 	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
 	"github.com/lwmacct/250402-m-geoip/api/v10/models"
 	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+	"github.com/lwmacct/250402-m-geoip/internal/ipclass"
 )
 
+// LookupOptions 查询选项
+type LookupOptions struct {
+	Policy Policy
+	Merge  bool   // 按字段合并多个来源, 每个字段取排名最高且非空的来源
+	Mode   string // CIDR 输入的匹配模式, 为空时精确匹配, 对IP输入无效
+	// 非公网IP默认按特殊用途地址注册表直接返回 ErrReservedAddress, 不查询数据;
+	// 数据库中维护了内网等网段时设置 Internal 照常查询
+	Internal bool
+}
+
+// LookupResult 查询结果, Candidates 为按策略排序后的全部候选记录
+type LookupResult struct {
+	Record     models.GeoIPV10
+	Policy     string
+	Candidates []Candidate
+	Provenance map[string]FieldSource // 合并模式下各字段的来源
+	Coverage   *Coverage              // 非精确匹配模式下查询网络的覆盖统计
+}
+
+// FieldSource 字段值的来源记录
+type FieldSource struct {
+	Source     string `json:"source"`
+	Cidr       string `json:"cidr"`
+	Confidence int    `json:"confidence"`
+}
+
 // SrvDBQuery 主服务结构体
 type SrvDBQuery struct {
 	once sync.Once
+	trie *SrvTrie
+	mmdb *SrvMMDB
 }
 
 // Init 初始化服务
 func (t *SrvDBQuery) Init() *SrvDBQuery {
 	t.once.Do(func() {
+		t.trie = srvTrie.Init()
+		t.mmdb = srvMMDB.Init()
 	})
 	return t
 }
 
 // GetIPInfo 根据输入自动区分IP和CIDR进行查询
 func (t *SrvDBQuery) GetIPInfo(input string) (models.GeoIPV10, error) {
+	result, err := t.Lookup(input, LookupOptions{Policy: DefaultPolicy()})
+	return result.Record, err
+}
+
+// Lookup 查询全部候选记录并按裁决策略选出结果
+func (t *SrvDBQuery) Lookup(input string, opt LookupOptions) (LookupResult, error) {
+	return t.lookup(input, opt, nil)
+}
+
+// lookup 查询单个输入, prefetched 中已有该输入的候选记录时不再查询数据库
+func (t *SrvDBQuery) lookup(input string, opt LookupOptions, prefetched map[string][]models.GeoIPV10) (LookupResult, error) {
+	policy, err := opt.Policy.WithName("")
+	if err != nil {
+		return LookupResult{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	mode, err := ParseCIDRMode(opt.Mode)
+	if err != nil {
+		return LookupResult{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if !opt.Internal {
+		if err := reservedAddress(input); err != nil {
+			return LookupResult{Policy: policy.Name}, err
+		}
+	}
+	if IsRange(input) {
+		// 只能拆分为单个网络的范围按 CIDR 查询, 多个网络由调用方通过 RangePrefixes 拆分后逐个查询
+		prefixes, err := RangePrefixes(input)
+		if err != nil {
+			return LookupResult{}, err
+		}
+		if len(prefixes) != 1 {
+			return LookupResult{}, fmt.Errorf("%w: IP范围 %s 包含 %d 个网络, 请拆分后查询", ErrInvalidInput, input, len(prefixes))
+		}
+		input = prefixes[0].String()
+	}
+	if prefix, perr := netip.ParsePrefix(input); perr == nil && mode != CIDRModeExact {
+		return t.lookupCIDR(prefix.Masked(), mode, policy)
+	}
+
+	result := LookupResult{Policy: policy.Name}
+	rows, ok := prefetched[input]
+	if !ok {
+		rows, err = t.queryDB(input)
+	}
+	if opt.Merge && (err == nil || errors.Is(err, ErrNotFound)) {
+		// 合并模式下 MaxMind 作为普通来源参与排序, 后端错误时直接返回错误
+		if record, ok := t.mmdbRecord(input); ok {
+			rows, err = append(rows, record), nil
+		}
+	}
+	if err == nil {
+		result.Candidates = policy.Rank(rows)
+		if len(result.Candidates) == 0 {
+			err = fmt.Errorf("%w: 未找到记录: %s", ErrNotFound, input)
+		} else {
+			result.Record = result.Candidates[0].GeoIPV10
+		}
+	}
+
+	if opt.Merge {
+		if err == nil {
+			result.Record, result.Provenance = mergeCandidates(result.Candidates)
+		}
+		return result, err
+	}
+
+	result.Record, err = t.withMMDB(input, result.Record, err)
+	return result, err
+}
+
+// reservedAddress 在查询数据之前按特殊用途地址注册表检查IP输入, 非公网地址返回 ErrReservedAddress
+func reservedAddress(input string) error {
+	addr, err := netip.ParseAddr(input)
+	if err != nil {
+		return nil
+	}
+	if info := ipclass.Classify(addr); !info.IsGlobal() {
+		return fmt.Errorf("%w: %s 为 %s 地址", ErrReservedAddress, input, info.Class)
+	}
+	return nil
+}
+
+// lookupCIDR 按匹配模式查询与网络相关的全部记录并统计覆盖情况
+// 只有覆盖整个查询网络的记录才会被选为结果, 没有时结果为空, 由调用方查看 Candidates 与 Coverage
+func (t *SrvDBQuery) lookupCIDR(prefix netip.Prefix, mode string, policy Policy) (LookupResult, error) {
+	result := LookupResult{Policy: policy.Name}
+	rows, err := t.queryDBMode(prefix, mode)
+	if err != nil {
+		return result, err
+	}
+	if len(rows) == 0 {
+		return result, fmt.Errorf("%w: 未找到记录: %s", ErrNotFound, prefix)
+	}
+	if len(rows) > maxCIDRMatches {
+		return result, fmt.Errorf("%w: 匹配的记录超过 %d 条, 请缩小查询范围或使用 /geoip/networks 分页反查", ErrInvalidInput, maxCIDRMatches)
+	}
+
+	result.Candidates = policy.Rank(rows)
+	result.Coverage = computeCoverage(prefix, rows)
+	for _, c := range result.Candidates {
+		if c.Bits <= prefix.Bits() {
+			result.Record = c.GeoIPV10
+			break
+		}
+	}
+	return result, nil
+}
+
+// IsRange 判断输入是否为 "起始IP-结束IP" 格式的范围
+func IsRange(input string) bool {
+	return strings.Contains(input, "-")
+}
+
+// RangePrefixes 将 "起始IP-结束IP" 格式的范围拆分为最少的 CIDR 列表
+func RangePrefixes(input string) ([]netip.Prefix, error) {
+	r, err := cidrset.ParseRange(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return r.Prefixes(), nil
+}
+
+// mergeCandidates 按排名依次补全各字段, 记录每个字段的来源
+// 合并结果的 source、cidr、confidence 取排名第一的记录
+func mergeCandidates(candidates []Candidate) (models.GeoIPV10, map[string]FieldSource) {
+	var merged models.GeoIPV10
+	provenance := map[string]FieldSource{}
+	if len(candidates) == 0 {
+		return merged, provenance
+	}
+
+	best := candidates[0]
+	merged.Model = best.Model
+	merged.Source, merged.Cidr, merged.Confidence = best.Source, best.Cidr, best.Confidence
+	for _, c := range candidates {
+		for _, field := range merged.FillEmpty(c.GeoIPV10) {
+			// extend 按键合并, 可能由多个来源补全, 只记录第一个
+			if _, ok := provenance[field]; !ok {
+				provenance[field] = FieldSource{Source: c.Source, Cidr: c.Cidr, Confidence: c.Confidence}
+			}
+		}
+	}
+	return merged, provenance
+}
+
+// mmdbRecord 查询 MaxMind 记录, 仅对IP输入且未关闭 MaxMind 时生效
+func (t *SrvDBQuery) mmdbRecord(input string) (models.GeoIPV10, bool) {
+	if app.Flag.App.MMDB.Mode == "off" || !t.mmdb.Ready() || net.ParseIP(input) == nil {
+		return models.GeoIPV10{}, false
+	}
+	record, err := t.mmdb.Lookup(input)
+	return record, err == nil
+}
+
+// withMMDB 按配置使用 MaxMind 数据兜底或补全数据库结果, 仅对IP输入生效
+func (t *SrvDBQuery) withMMDB(input string, geoip models.GeoIPV10, err error) (models.GeoIPV10, error) {
+	if err == nil && app.Flag.App.MMDB.Mode != "merge" {
+		return geoip, err
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		// 后端错误和无效输入不兜底, 否则数据库故障时会以 MaxMind 数据正常返回
+		return geoip, err
+	}
+
+	record, ok := t.mmdbRecord(input)
+	if !ok {
+		return geoip, err
+	}
+	if err != nil {
+		// 数据库未命中, 使用 MaxMind 结果
+		return record, nil
+	}
+	geoip.FillEmpty(record)
+	return geoip, nil
+}
+
+// queryDB 从数据库或内存前缀树查询全部候选记录
+func (t *SrvDBQuery) queryDB(input string) ([]models.GeoIPV10, error) {
 	// 检查数据库连接
 	if app.DB == nil {
 		mlog.Error(mlog.H{"msg": "数据库连接未初始化"})
-		return models.GeoIPV10{}, fmt.Errorf("数据库连接未初始化")
+		return nil, fmt.Errorf("%w: 数据库连接未初始化", ErrBackend)
+	}
+
+	// 前缀树加载完成后优先使用内存查询
+	if t.trie.Ready() {
+		return t.queryByTrie(input)
 	}
 
 	// 判断输入是IP还是CIDR
 	if _, _, err := net.ParseCIDR(input); err == nil {
 		// 输入是CIDR格式
-		return t.queryByCIDR(input)
+		return t.queryByCIDR(input, CIDRModeExact)
 	} else {
 		// 输入可能是IP格式
 		ip := net.ParseIP(input)
 		if ip == nil {
-			return models.GeoIPV10{}, fmt.Errorf("无效的输入格式: %s", input)
+			return nil, fmt.Errorf("%w: 无效的输入格式: %s", ErrInvalidInput, input)
 		}
 		return t.queryByIP(ip.String())
 	}
 }
 
-// queryByIP 通过IP地址查询信息
-func (t *SrvDBQuery) queryByIP(ipAddr string) (models.GeoIPV10, error) {
+// queryDBMode 按匹配模式从数据库或内存前缀树查询CIDR相关的记录
+func (t *SrvDBQuery) queryDBMode(prefix netip.Prefix, mode string) ([]models.GeoIPV10, error) {
+	if app.DB == nil {
+		mlog.Error(mlog.H{"msg": "数据库连接未初始化"})
+		return nil, fmt.Errorf("%w: 数据库连接未初始化", ErrBackend)
+	}
+
+	if !t.trie.Ready() {
+		return t.queryByCIDR(prefix.String(), mode)
+	}
+
+	switch mode {
+	// 与数据库查询一致, 最多收集 maxCIDRMatches+1 条, 超出上限由调用方报错
+	case CIDRModeContained:
+		return t.trie.Subnets(prefix, maxCIDRMatches+1), nil
+	case CIDRModeContaining:
+		return t.trie.Supernets(prefix), nil
+	case CIDRModeOverlapping:
+		// 两个网络重叠时必有一方包含另一方, 查询网络自身只在 Supernets 中计入一次
+		// Subnets 最先返回查询网络自身, 上限需加上这部分记录
+		rows := t.trie.Supernets(prefix)
+		limit := maxCIDRMatches + 1 - len(rows) + len(t.trie.Get(prefix))
+		for _, row := range t.trie.Subnets(prefix, max(limit, 1)) {
+			if p, err := netip.ParsePrefix(row.Cidr); err != nil || p.Masked() != prefix {
+				rows = append(rows, row)
+			}
+		}
+		return rows, nil
+	}
+	return t.trie.Get(prefix), nil
+}
+
+// queryByTrie 通过内存前缀树查询, IP 返回所有包含它的网络, CIDR 使用精确匹配
+func (t *SrvDBQuery) queryByTrie(input string) ([]models.GeoIPV10, error) {
+	if prefix, err := netip.ParsePrefix(input); err == nil {
+		return t.trie.Get(prefix), nil
+	}
+
+	addr, err := netip.ParseAddr(input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 无效的输入格式: %s", ErrInvalidInput, input)
+	}
+	return t.trie.Matches(addr), nil
+}
+
+// queryByIP 通过IP地址查询所有包含它的网络
+func (t *SrvDBQuery) queryByIP(ipAddr string) ([]models.GeoIPV10, error) {
 	// 验证IP地址格式
 	ip := net.ParseIP(ipAddr)
 	if ip == nil {
-		return models.GeoIPV10{}, fmt.Errorf("无效的IP地址: %s", ipAddr)
+		return nil, fmt.Errorf("%w: 无效的IP地址: %s", ErrInvalidInput, ipAddr)
 	}
 
-	// 使用PostgreSQL的网络包含查询操作符 >>
-	var geoip models.GeoIPV10
-	result := app.DB.Where("cidr >> ?", ipAddr).Order("confidence DESC").First(&geoip)
+	// 使用PostgreSQL的网络包含查询操作符 >>=, 结果由裁决策略排序
+	var rows []models.GeoIPV10
+	result := app.DB.Where("cidr >>= ?", ipAddr).Find(&rows)
 
 	if result.Error != nil {
 		mlog.Error(mlog.H{"msg": "IP查询失败", "ip": ipAddr, "err": result.Error.Error()})
-		return models.GeoIPV10{}, fmt.Errorf("数据库查询错误: %v", result.Error)
+		return nil, fmt.Errorf("%w: 数据库查询错误: %v", ErrBackend, result.Error)
 	}
 
-	mlog.Info(mlog.H{"msg": "数据库IP查询成功", "ip": ipAddr})
-	return geoip, nil
+	mlog.Info(mlog.H{"msg": "数据库IP查询成功", "ip": ipAddr, "rows": len(rows)})
+	return rows, nil
 }
 
-// queryByCIDR 通过CIDR查询信息
-func (t *SrvDBQuery) queryByCIDR(cidr string) (models.GeoIPV10, error) {
+// queryByCIDR 通过CIDR查询信息, mode 决定使用的网络运算符
+func (t *SrvDBQuery) queryByCIDR(cidr string, mode string) ([]models.GeoIPV10, error) {
 	// 验证CIDR格式
 	_, _, err := net.ParseCIDR(cidr)
 	if err != nil {
-		return models.GeoIPV10{}, fmt.Errorf("无效的CIDR格式: %s, %v", cidr, err)
+		return nil, fmt.Errorf("%w: 无效的CIDR格式: %s, %v", ErrInvalidInput, cidr, err)
 	}
 
-	// 直接匹配CIDR
-	var geoip models.GeoIPV10
-	result := app.DB.Where("cidr = ?", cidr).Order("confidence DESC").First(&geoip)
+	// 多取一条用于判断是否超出上限
+	var rows []models.GeoIPV10
+	result := app.DB.Where("cidr "+cidrOperator(mode)+" ?", cidr).Order("id").Limit(maxCIDRMatches + 1).Find(&rows)
 
 	if result.Error != nil {
-		mlog.Error(mlog.H{"msg": "CIDR查询失败", "cidr": cidr, "err": result.Error.Error()})
-		return models.GeoIPV10{}, fmt.Errorf("数据库查询错误: %v", result.Error)
+		mlog.Error(mlog.H{"msg": "CIDR查询失败", "cidr": cidr, "mode": mode, "err": result.Error.Error()})
+		return nil, fmt.Errorf("%w: 数据库查询错误: %v", ErrBackend, result.Error)
 	}
 
-	mlog.Info(mlog.H{"msg": "数据库CIDR查询成功", "cidr": cidr})
-	return geoip, nil
+	mlog.Info(mlog.H{"msg": "数据库CIDR查询成功", "cidr": cidr, "mode": mode, "rows": len(rows)})
+	return rows, nil
 }
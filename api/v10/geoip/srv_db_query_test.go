@@ -0,0 +1,34 @@
+package geoip
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLookupReserved(t *testing.T) {
+	// 数据库未初始化, 需要查询数据的输入均返回后端错误
+	q := &SrvDBQuery{trie: &SrvTrie{}}
+	tests := []struct {
+		input    string
+		internal bool
+		want     error
+	}{
+		{"10.0.0.1", false, ErrReservedAddress},
+		{"127.0.0.1", false, ErrReservedAddress},
+		{"::ffff:192.168.1.1", false, ErrReservedAddress},
+		{"fe80::1", false, ErrReservedAddress},
+		{"2001:db8::1", false, ErrReservedAddress},
+		{"8.8.8.8", false, ErrBackend},
+		{"2400:3200::1", false, ErrBackend},
+		// 网络输入不按地址分类拦截
+		{"10.0.0.0/8", false, ErrBackend},
+		// 显式查询内网地址
+		{"10.0.0.1", true, ErrBackend},
+	}
+	for _, tt := range tests {
+		_, err := q.Lookup(tt.input, LookupOptions{Policy: DefaultPolicy(), Internal: tt.internal})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Lookup(%s, internal=%v) = %v, want %v", tt.input, tt.internal, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package geoip
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+)
+
+// 反查分页大小
+const (
+	searchDefaultLimit = 100
+	searchMaxLimit     = 1000
+)
+
+// NetworkItem 反查结果中的一条记录, id 用作分页游标
+type NetworkItem struct {
+	ID uint `json:"id"`
+	models.GeoIPV10
+}
+
+// FamilyStats 某个地址族的统计
+type FamilyStats struct {
+	Networks  int64  `json:"networks"`
+	Addresses string `json:"addresses"` // 各网络地址数之和, IPv6 超出 64 位整数范围, 使用字符串
+}
+
+// SearchResult 反查结果
+type SearchResult struct {
+	Networks   []NetworkItem          `json:"networks"`
+	Total      int64                  `json:"total"`
+	Families   map[string]FamilyStats `json:"families"`              // ipv4 / ipv6
+	NextCursor uint                   `json:"next_cursor,omitempty"` // 为 0 表示没有更多数据
+}
+
+// SrvDBSearch 按地理位置或 ASN 反查网络
+type SrvDBSearch struct {
+	once sync.Once
+}
+
+// Init 初始化服务
+func (t *SrvDBSearch) Init() *SrvDBSearch {
+	t.once.Do(func() {})
+	return t
+}
+
+// Search 按条件分页查询网络, cursor 为上一页返回的 next_cursor
+// total 与 families 统计全部符合条件的记录, 不受分页影响; 重叠的网络地址数重复计算
+func (t *SrvDBSearch) Search(filter NetworkFilter, cursor uint, limit int) (SearchResult, error) {
+	result := SearchResult{Networks: []NetworkItem{}, Families: map[string]FamilyStats{}}
+	if app.DB == nil {
+		return result, fmt.Errorf("%w: 数据库连接未初始化", ErrBackend)
+	}
+	if filter.IsEmpty() && filter.MinConfidence == 0 {
+		return result, fmt.Errorf("至少需要一个筛选条件: country_code, province, city, district, asn, isp, source, min_confidence")
+	}
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	limit = min(limit, searchMaxLimit)
+
+	// 多取一条判断是否还有下一页
+	var rows []models.GeoIPV10
+	err := filter.Apply(app.DB.Model(&models.GeoIPV10{})).
+		Where("id > ?", cursor).Order("id").Limit(limit + 1).Find(&rows).Error
+	if err != nil {
+		mlog.Error(mlog.H{"msg": "反查网络失败", "filter": filter, "err": err.Error()})
+		return result, fmt.Errorf("%w: 数据库查询错误: %v", ErrBackend, err)
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		result.NextCursor = rows[limit-1].ID
+	}
+	for _, row := range rows {
+		result.Networks = append(result.Networks, NetworkItem{ID: row.ID, GeoIPV10: row})
+	}
+
+	var stats []struct {
+		Family    int
+		Networks  int64
+		Addresses string
+	}
+	err = filter.Apply(app.DB.Model(&models.GeoIPV10{})).
+		Select("family(cidr) AS family, count(*) AS networks, " +
+			"round(sum(power(2::numeric, CASE WHEN family(cidr) = 4 THEN 32 ELSE 128 END - masklen(cidr))))::text AS addresses").
+		Group("family(cidr)").Scan(&stats).Error
+	if err != nil {
+		mlog.Error(mlog.H{"msg": "反查统计失败", "filter": filter, "err": err.Error()})
+		return result, fmt.Errorf("%w: 数据库查询错误: %v", ErrBackend, err)
+	}
+	for _, s := range stats {
+		result.Families[fmt.Sprintf("ipv%d", s.Family)] = FamilyStats{Networks: s.Networks, Addresses: s.Addresses}
+		result.Total += s.Networks
+	}
+	return result, nil
+}
@@ -0,0 +1,261 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 单次请求允许写入的最大条目数
+const writeBatchLimit = 1000
+
+// 写入结果状态
+const (
+	WriteStatusCreated  = "created"
+	WriteStatusUpdated  = "updated"
+	WriteStatusDeleted  = "deleted"
+	WriteStatusNotFound = "not_found"
+	WriteStatusInvalid  = "invalid"
+	WriteStatusError    = "error"
+)
+
+// WriteResult 单条写入操作的结果
+type WriteResult struct {
+	Index  int    `json:"index"`
+	Source string `json:"source"`
+	Cidr   string `json:"cidr"`
+	Status string `json:"status"`
+	Err    string `json:"err,omitempty"`
+}
+
+// Failed 判断该条目是否失败
+func (r WriteResult) Failed() bool {
+	return r.Status == WriteStatusInvalid || r.Status == WriteStatusError || r.Status == WriteStatusNotFound
+}
+
+// SrvDBWrite 数据写入服务, 按 (source, cidr) 唯一键更新或删除记录
+type SrvDBWrite struct {
+	once sync.Once
+	trie *SrvTrie
+}
+
+// Init 初始化服务
+func (t *SrvDBWrite) Init() *SrvDBWrite {
+	t.once.Do(func() {
+		t.trie = srvTrie.Init()
+	})
+	return t
+}
+
+// Upsert 按 (source, cidr) 插入或更新记录, 仅更新请求中出现的字段
+func (t *SrvDBWrite) Upsert(body []byte) ([]WriteResult, error) {
+	items, err := splitBatchBody(body)
+	if err != nil {
+		return nil, err
+	}
+	if app.DB == nil {
+		return nil, fmt.Errorf("数据库连接未初始化")
+	}
+
+	results := make([]WriteResult, 0, len(items))
+	for i, raw := range items {
+		result := WriteResult{Index: i}
+		row, fields, err := decodeUpsertItem(raw)
+		result.Source, result.Cidr = row.Source, row.Cidr
+		if err != nil {
+			result.Status, result.Err = WriteStatusInvalid, err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		status, err := t.upsertRow(row, fields)
+		if err != nil {
+			mlog.Error(mlog.H{"msg": "记录写入失败", "source": row.Source, "cidr": row.Cidr, "err": err.Error()})
+			result.Status, result.Err = WriteStatusError, err.Error()
+		} else {
+			result.Status = status
+		}
+		results = append(results, result)
+	}
+	t.trie.Notify()
+	return results, nil
+}
+
+// Delete 按 (source, cidr) 删除记录
+func (t *SrvDBWrite) Delete(body []byte) ([]WriteResult, error) {
+	items, err := splitBatchBody(body)
+	if err != nil {
+		return nil, err
+	}
+	if app.DB == nil {
+		return nil, fmt.Errorf("数据库连接未初始化")
+	}
+
+	results := make([]WriteResult, 0, len(items))
+	for i, raw := range items {
+		result := WriteResult{Index: i}
+		var key struct {
+			Source string `json:"source"`
+			Cidr   string `json:"cidr"`
+		}
+		if err := strictUnmarshal(raw, &key); err != nil {
+			result.Status, result.Err = WriteStatusInvalid, err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Source, result.Cidr = key.Source, key.Cidr
+
+		source, cidr, err := validateWriteKey(key.Source, key.Cidr)
+		if err != nil {
+			result.Status, result.Err = WriteStatusInvalid, err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Cidr = cidr
+
+		// 硬删除, 避免软删除的行继续占用 (source, cidr) 唯一索引
+		tx := app.DB.Unscoped().Where("source = ? AND cidr = ?", source, cidr).Delete(&models.GeoIPV10{})
+		switch {
+		case tx.Error != nil:
+			mlog.Error(mlog.H{"msg": "记录删除失败", "source": source, "cidr": cidr, "err": tx.Error.Error()})
+			result.Status, result.Err = WriteStatusError, tx.Error.Error()
+		case tx.RowsAffected == 0:
+			result.Status = WriteStatusNotFound
+		default:
+			result.Status = WriteStatusDeleted
+		}
+		results = append(results, result)
+	}
+	t.trie.Notify()
+	return results, nil
+}
+
+// upsertRow 写入单条记录, 返回 created 或 updated
+func (t *SrvDBWrite) upsertRow(row models.GeoIPV10, fields []string) (string, error) {
+	status := WriteStatusCreated
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.GeoIPV10{}).Unscoped().
+			Where("source = ? AND cidr = ?", row.Source, row.Cidr).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			status = WriteStatusUpdated
+		}
+
+		// 冲突时只覆盖请求中出现的字段, 同时恢复可能被软删除的记录
+		columns := append(fields, "updated_at", "deleted_at")
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "source"}, {Name: "cidr"}},
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).Create(&row).Error
+	})
+	return status, err
+}
+
+// splitBatchBody 将请求体拆分为条目列表, 支持单个对象或对象数组
+func splitBatchBody(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, fmt.Errorf("请求体不能为空")
+	}
+
+	var items []json.RawMessage
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, fmt.Errorf("无效的请求数据: %v", err)
+		}
+	} else {
+		items = []json.RawMessage{json.RawMessage(body)}
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("条目列表不能为空")
+	}
+	if len(items) > writeBatchLimit {
+		return nil, fmt.Errorf("单次最多写入 %d 条, 当前 %d 条", writeBatchLimit, len(items))
+	}
+	return items, nil
+}
+
+// decodeUpsertItem 解析并校验单条写入数据, 返回记录和请求中出现的字段列表
+func decodeUpsertItem(raw json.RawMessage) (models.GeoIPV10, []string, error) {
+	var row models.GeoIPV10
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &present); err != nil {
+		return row, nil, fmt.Errorf("条目必须是 JSON 对象: %v", err)
+	}
+	if err := strictUnmarshal(raw, &row); err != nil {
+		return row, nil, err
+	}
+
+	source, cidr, err := validateWriteKey(row.Source, row.Cidr)
+	if err != nil {
+		return row, nil, err
+	}
+	row.Source, row.Cidr = source, cidr
+
+	if err := row.Validate(); err != nil {
+		return row, nil, err
+	}
+
+	// 字段名与列名一致, 主键字段不参与更新
+	fields := make([]string, 0, len(present))
+	for _, column := range getWritableColumns() {
+		if column == "source" || column == "cidr" {
+			continue
+		}
+		if _, ok := present[column]; ok {
+			fields = append(fields, column)
+		}
+	}
+	return row, fields, nil
+}
+
+// validateWriteKey 校验并规范化 (source, cidr)
+func validateWriteKey(source, cidr string) (string, string, error) {
+	if source == "" {
+		return source, cidr, fmt.Errorf("source 不能为空")
+	}
+	if len(source) > 32 {
+		return source, cidr, fmt.Errorf("source 长度不能超过 32: %s", source)
+	}
+
+	normalized, err := models.NormalizeCidr(cidr)
+	if err != nil {
+		return source, cidr, err
+	}
+	return source, normalized, nil
+}
+
+// strictUnmarshal 解析 JSON 并拒绝未知字段
+func strictUnmarshal(raw json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fmt.Errorf("字段 %s 类型错误, 需要 %s", typeErr.Field, typeErr.Type)
+		}
+		return fmt.Errorf("无效的条目: %v", err)
+	}
+	return nil
+}
+
+// getWritableColumns 返回可通过接口写入的列名
+func getWritableColumns() []string {
+	return []string{
+		"extend", "source", "confidence", "isp", "cidr", "eswn", "continent",
+		"country", "country_code", "country_english", "province", "city",
+		"district", "area_code", "latitude", "longitude", "asn", "asn_org",
+	}
+}
@@ -2,58 +2,354 @@ package geoip
 
 import (
 	"fmt"
+	"maps"
 	"net"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
 	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
 )
 
+// MaxMind 数据写入 GeoIPV10 时使用的来源名称
+const mmdbSource = "maxmind"
+
+// 扁平布局导出文件的 database_type
+const mmdbFlatType = "GeoIPV10"
+
+// 全局共享的 MaxMind 数据库服务
+var srvMMDB = new(SrvMMDB)
+
+// MaxMind 数据库类型
+const (
+	mmdbTypeCity = iota + 1
+	mmdbTypeEnterprise
+	mmdbTypeASN
+	mmdbTypeISP
+	mmdbTypeConnectionType
+	mmdbTypeAnonymousIP
+	mmdbTypeDomain
+	mmdbTypeFlat // export mmdb --export-layout flat 生成的数据库, 字段与 GeoIPV10 的 json 标签一致
+)
+
+// mmdbReader 单个 MaxMind 数据库文件
+type mmdbReader struct {
+	file   string
+	kind   int
+	reader *maxminddb.Reader
+}
+
 // SrvMMDB 封装MaxMind数据库相关功能
 type SrvMMDB struct {
 	once sync.Once
-	db   []*geoip2.Reader
+	db   []*mmdbReader
 }
 
 // Init 初始化MaxMind数据库
-func (t *SrvMMDB) Init() {
+func (t *SrvMMDB) Init() *SrvMMDB {
 	t.once.Do(func() {
-		dirList := t.findSuffixFile(os.Getenv("GOPKG_MMDB_DIR"), ".mmdb")
+		dir := app.Flag.App.MMDB.Dir
+		if dir == "" {
+			dir = os.Getenv("GOPKG_MMDB_DIR")
+		}
+		dirList := t.findSuffixFile(dir, ".mmdb")
 		for _, v := range dirList {
-			mlog.Info(mlog.H{"msg": "初始化MaxMind数据库", "file": v})
-			db, err := geoip2.Open(v)
+			db, err := maxminddb.Open(v)
 			if err != nil {
 				mlog.Error(mlog.H{"msg": "打开文件失败", "file": v, "err": err.Error()})
 				continue
 			}
-			t.db = append(t.db, db)
+			kind := mmdbKind(db.Metadata.DatabaseType)
+			if kind == 0 {
+				mlog.Warn(mlog.H{"msg": "不支持的MaxMind数据库类型", "file": v, "type": db.Metadata.DatabaseType})
+				db.Close()
+				continue
+			}
+			mlog.Info(mlog.H{"msg": "初始化MaxMind数据库", "file": v, "type": db.Metadata.DatabaseType})
+			t.db = append(t.db, &mmdbReader{file: v, kind: kind, reader: db})
 		}
 	})
+	return t
 }
 
-// GetIP 从MaxMind数据库查询IP信息
+// Ready 是否加载了可用的数据库
+func (t *SrvMMDB) Ready() bool {
+	return len(t.db) > 0
+}
+
+// GetIP 从MaxMind City数据库查询IP信息
 func (t *SrvMMDB) GetIP(ipAddr string) (*geoip2.City, error) {
+	// 解析用户提供的IP地址
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("无效的IP地址: %s", ipAddr)
+	}
+
+	for _, db := range t.db {
+		if db.kind != mmdbTypeCity && db.kind != mmdbTypeEnterprise {
+			continue
+		}
+		var record geoip2.City
+		if err := db.reader.Lookup(ip, &record); err != nil {
+			mlog.Error(mlog.H{"msg": "MaxMind IP查询失败", "ip": ipAddr, "file": db.file, "err": err.Error()})
+			return nil, err
+		}
+		return &record, nil
+	}
+
+	mlog.Error(mlog.H{"msg": "MaxMind City数据库为空"})
+	return nil, fmt.Errorf("MaxMind City数据库未初始化")
+}
+
+// Lookup 查询所有已加载的数据库并合并为一条记录
+// 各数据库命中的网络互相包含, 记录的 cidr 取其中最具体的网络
+func (t *SrvMMDB) Lookup(ipAddr string) (models.GeoIPV10, error) {
 	if len(t.db) == 0 {
-		mlog.Error(mlog.H{"msg": "MaxMind数据库为空"})
-		return nil, fmt.Errorf("MaxMind IP数据库未初始化")
+		return models.GeoIPV10{}, fmt.Errorf("MaxMind IP数据库未初始化")
 	}
 
-	// 解析用户提供的IP地址
 	ip := net.ParseIP(ipAddr)
 	if ip == nil {
-		return nil, fmt.Errorf("无效的IP地址: %s", ipAddr)
+		return models.GeoIPV10{}, fmt.Errorf("无效的IP地址: %s", ipAddr)
 	}
 
-	// 查询IP地址信息
-	record, err := t.db[0].City(ip)
-	if err != nil {
-		mlog.Error(mlog.H{"msg": "MaxMind IP查询失败", "ip": ipAddr, "err": err.Error()})
-		return nil, err
+	geoip := models.GeoIPV10{Source: mmdbSource, Confidence: app.Flag.App.MMDB.Confidence}
+	extend := map[string]interface{}{}
+	var network *net.IPNet
+	for _, db := range t.db {
+		found, ipNet, err := db.lookup(ip, &geoip, extend)
+		if err != nil {
+			mlog.Error(mlog.H{"msg": "MaxMind IP查询失败", "ip": ipAddr, "file": db.file, "err": err.Error()})
+			continue
+		}
+		if !found {
+			continue
+		}
+		if ones, _ := ipNet.Mask.Size(); network == nil || ones > maskOnes(network) {
+			network = ipNet
+		}
+	}
+
+	if network == nil {
+		return models.GeoIPV10{}, fmt.Errorf("MaxMind 未找到IP: %s", ipAddr)
+	}
+	geoip.Cidr = network.String()
+	if err := geoip.SetExtendData(extend); err != nil {
+		mlog.Error(mlog.H{"msg": "MaxMind 扩展字段序列化失败", "ip": ipAddr, "err": err.Error()})
+	}
+	return geoip, nil
+}
+
+// lookup 查询IP所在网络, 按数据库类型解码并写入 geoip 与 extend
+func (r *mmdbReader) lookup(ip net.IP, geoip *models.GeoIPV10, extend map[string]interface{}) (bool, *net.IPNet, error) {
+	return r.decode(func(record any) (*net.IPNet, bool, error) {
+		return r.reader.LookupNetwork(ip, record)
+	}, geoip, extend)
+}
+
+// decode 使用 read 读取一条记录, 按数据库类型解码并写入 geoip 与 extend
+// 扁平布局和未知类型的数据库按 GeoIPV10 的 json 字段名映射, 其余字段写入 extend
+func (r *mmdbReader) decode(read func(record any) (*net.IPNet, bool, error), geoip *models.GeoIPV10, extend map[string]interface{}) (bool, *net.IPNet, error) {
+	locale := app.Flag.App.MMDB.Locale
+
+	switch r.kind {
+	case mmdbTypeCity, mmdbTypeEnterprise:
+		var record geoip2.Enterprise
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		setEmpty(&geoip.Continent, localName(record.Continent.Names, locale))
+		setEmpty(&geoip.Country, localName(record.Country.Names, locale))
+		setEmpty(&geoip.CountryCode, record.Country.IsoCode)
+		setEmpty(&geoip.CountryEnglish, record.Country.Names["en"])
+		if len(record.Subdivisions) > 0 {
+			setEmpty(&geoip.Province, localName(record.Subdivisions[0].Names, locale))
+		}
+		setEmpty(&geoip.City, localName(record.City.Names, locale))
+		if geoip.Latitude == 0 && geoip.Longitude == 0 {
+			geoip.Latitude, geoip.Longitude = record.Location.Latitude, record.Location.Longitude
+		}
+		setExtend(extend, "continent_code", record.Continent.Code)
+		setExtend(extend, "postal_code", record.Postal.Code)
+		setExtend(extend, "time_zone", record.Location.TimeZone)
+		setExtend(extend, "accuracy_radius", record.Location.AccuracyRadius)
+		setExtend(extend, "registered_country_code", record.RegisteredCountry.IsoCode)
+		setExtend(extend, "is_in_european_union", record.Country.IsInEuropeanUnion)
+		setExtend(extend, "is_anonymous_proxy", record.Traits.IsAnonymousProxy)
+		setExtend(extend, "is_anycast", record.Traits.IsAnycast)
+		setExtend(extend, "is_satellite_provider", record.Traits.IsSatelliteProvider)
+		if r.kind == mmdbTypeEnterprise {
+			setEmpty(&geoip.ISP, record.Traits.ISP)
+			setEmpty(&geoip.ASNOrg, record.Traits.AutonomousSystemOrganization)
+			if geoip.ASN == 0 {
+				geoip.ASN = int(record.Traits.AutonomousSystemNumber)
+			}
+			setExtend(extend, "organization", record.Traits.Organization)
+			setExtend(extend, "connection_type", record.Traits.ConnectionType)
+			setExtend(extend, "user_type", record.Traits.UserType)
+			setExtend(extend, "domain", record.Traits.Domain)
+		}
+		return true, network, nil
+
+	case mmdbTypeASN:
+		var record geoip2.ASN
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		if geoip.ASN == 0 {
+			geoip.ASN = int(record.AutonomousSystemNumber)
+		}
+		setEmpty(&geoip.ASNOrg, record.AutonomousSystemOrganization)
+		return true, network, nil
+
+	case mmdbTypeISP:
+		var record geoip2.ISP
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		setEmpty(&geoip.ISP, record.ISP)
+		setEmpty(&geoip.ASNOrg, record.AutonomousSystemOrganization)
+		if geoip.ASN == 0 {
+			geoip.ASN = int(record.AutonomousSystemNumber)
+		}
+		setExtend(extend, "organization", record.Organization)
+		setExtend(extend, "mobile_country_code", record.MobileCountryCode)
+		setExtend(extend, "mobile_network_code", record.MobileNetworkCode)
+		return true, network, nil
+
+	case mmdbTypeConnectionType:
+		var record geoip2.ConnectionType
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		setExtend(extend, "connection_type", record.ConnectionType)
+		return true, network, nil
+
+	case mmdbTypeAnonymousIP:
+		var record geoip2.AnonymousIP
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		setExtend(extend, "is_anonymous", record.IsAnonymous)
+		setExtend(extend, "is_anonymous_vpn", record.IsAnonymousVPN)
+		setExtend(extend, "is_hosting_provider", record.IsHostingProvider)
+		setExtend(extend, "is_public_proxy", record.IsPublicProxy)
+		setExtend(extend, "is_residential_proxy", record.IsResidentialProxy)
+		setExtend(extend, "is_tor_exit_node", record.IsTorExitNode)
+		return true, network, nil
+
+	case mmdbTypeDomain:
+		var record geoip2.Domain
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		setExtend(extend, "domain", record.Domain)
+		return true, network, nil
+
+	default:
+		var record map[string]interface{}
+		network, ok, err := read(&record)
+		if err != nil || !ok {
+			return false, network, err
+		}
+		var flat models.GeoIPV10
+		for k, v := range flat.SetFields(record) {
+			if _, ok := extend[k]; !ok {
+				extend[k] = v
+			}
+		}
+		geoip.FillEmpty(flat)
+		// 扁平布局的记录自带可信度
+		if flat.Confidence > 0 {
+			geoip.Confidence = flat.Confidence
+		}
+		return true, network, nil
+	}
+}
+
+// mmdbKind 根据元数据中的 database_type 判断数据库类型
+func mmdbKind(databaseType string) int {
+	switch {
+	case strings.HasPrefix(databaseType, mmdbFlatType):
+		return mmdbTypeFlat
+	case strings.Contains(databaseType, "Enterprise"):
+		return mmdbTypeEnterprise
+	case strings.Contains(databaseType, "Anonymous-IP"):
+		return mmdbTypeAnonymousIP
+	case strings.Contains(databaseType, "Connection-Type"):
+		return mmdbTypeConnectionType
+	case strings.Contains(databaseType, "Domain"):
+		return mmdbTypeDomain
+	case strings.Contains(databaseType, "ISP"):
+		return mmdbTypeISP
+	case strings.Contains(databaseType, "ASN"):
+		return mmdbTypeASN
+	case strings.Contains(databaseType, "City"),
+		strings.Contains(databaseType, "Country"),
+		strings.Contains(databaseType, "Location"):
+		return mmdbTypeCity
 	}
+	return 0
+}
+
+// localName 按语言取名称, 未找到时依次回退到英文和按语言代码排序的第一个名称, 同一记录的结果固定
+func localName(names map[string]string, locale string) string {
+	if v := names[locale]; v != "" {
+		return v
+	}
+	if v := names["en"]; v != "" {
+		return v
+	}
+	for _, lang := range slices.Sorted(maps.Keys(names)) {
+		if v := names[lang]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// setEmpty 仅在目标为空时赋值
+func setEmpty(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+// setExtend 仅写入非零值到扩展字段
+func setExtend(extend map[string]interface{}, key string, v interface{}) {
+	switch val := v.(type) {
+	case string:
+		if val == "" {
+			return
+		}
+	case bool:
+		if !val {
+			return
+		}
+	case uint16:
+		if val == 0 {
+			return
+		}
+	}
+	if _, ok := extend[key]; !ok {
+		extend[key] = v
+	}
+}
 
-	mlog.Info(mlog.H{"msg": "MaxMind IP查询成功", "ip": ipAddr})
-	return record, nil
+func maskOnes(n *net.IPNet) int {
+	ones, _ := n.Mask.Size()
+	return ones
 }
 
 // 取得某目录下指定后缀的文件路径
@@ -0,0 +1,367 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/iptrie"
+	"github.com/lwmacct/250402-m-geoip/internal/mmdbwriter"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// 导出 .mmdb 的记录布局
+const (
+	MMDBLayoutCity = "city" // 兼容 GeoIP2-City, 可使用 geoip2 等现有客户端读取
+	MMDBLayoutFlat = "flat" // 字段与 GeoIPV10 的 json 标签一致
+)
+
+// MMDBExportOptions 导出选项
+type MMDBExportOptions struct {
+	Layout       string
+	DatabaseType string // 为空时 city 布局使用 GeoIP2-City, flat 布局使用 GeoIPV10
+	Locale       string // city 布局中 country/province/city 名称使用的语言
+	Policy       Policy // 多个来源覆盖同一网络时的裁决策略
+	Verify       bool   // 写入后重新打开文件逐个网络校验
+}
+
+// MMDBExportStats 导出统计
+type MMDBExportStats struct {
+	Networks int `json:"networks"`
+	Records  int `json:"records"` // 数据段中去重后的记录数
+	Verified int `json:"verified"`
+}
+
+// ExportMMDB 将 geoip_v10 导出为 MaxMind DB 文件
+//
+// 每个网络按裁决策略从覆盖它的全部记录中选出一条, 与在线查询的结果一致; 文件先写入临时文件再重命名
+func ExportMMDB(path string, opts MMDBExportOptions) (MMDBExportStats, error) {
+	var stats MMDBExportStats
+	if app.DB == nil {
+		return stats, fmt.Errorf("数据库连接未初始化")
+	}
+
+	policy, err := opts.Policy.WithName("")
+	if err != nil {
+		return stats, err
+	}
+	var layout func(models.GeoIPV10, string) map[string]any
+	switch opts.Layout {
+	case MMDBLayoutCity:
+		layout = mmdbCityRecord
+		if opts.DatabaseType == "" {
+			opts.DatabaseType = "GeoIP2-City"
+		}
+	case MMDBLayoutFlat:
+		layout = mmdbFlatRecord
+		if opts.DatabaseType == "" {
+			opts.DatabaseType = mmdbFlatType
+		}
+	default:
+		return stats, fmt.Errorf("不支持的布局: %s, 可选 %s, %s", opts.Layout, MMDBLayoutCity, MMDBLayoutFlat)
+	}
+	if opts.Locale == "" {
+		opts.Locale = "en"
+	}
+
+	tree, rows, err := loadTree(app.DB.Model(&models.GeoIPV10{}))
+	if err != nil {
+		return stats, err
+	}
+	mlog.Info(mlog.H{"msg": "ExportMMDB: rows loaded", "prefixes": tree.Len(), "rows": rows})
+
+	languages := []string{"en"}
+	if opts.Locale != "en" {
+		languages = append(languages, opts.Locale)
+	}
+	writer := mmdbwriter.New(mmdbwriter.Metadata{
+		DatabaseType: opts.DatabaseType,
+		Languages:    languages,
+		Description: map[string]string{
+			"en": fmt.Sprintf("geoip_v10 export, layout %s, policy %s", opts.Layout, policy.Name),
+		},
+	})
+
+	// 写入器按前缀长度处理重叠网络, 更具体的网络优先, 与遍历顺序无关
+	chosen := iptrie.New[models.GeoIPV10]()
+	tree.Walk(func(p netip.Prefix, _ []models.GeoIPV10) bool {
+		if mmdbwriter.IPv4Reserved(p) {
+			mlog.Warn(mlog.H{"msg": "ExportMMDB: 跳过 ::/96 内的 IPv6 网络, 该范围在 mmdb 中表示 IPv4", "cidr": p.String()})
+			return true
+		}
+		best := resolveNetwork(tree, p, policy)
+		chosen.Insert(p, best)
+		if err = writer.Insert(p, layout(best, opts.Locale)); err != nil {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.Networks, stats.Records = writer.Networks(), writer.Records()
+
+	if err := writeFileAtomic(path, writer); err != nil {
+		return stats, err
+	}
+	mlog.Info(mlog.H{"msg": "ExportMMDB: written", "file": path, "networks": stats.Networks, "records": stats.Records})
+
+	if opts.Verify {
+		stats.Verified, err = verifyMMDB(path, opts, chosen)
+	}
+	return stats, err
+}
+
+// resolveNetwork 按裁决策略从覆盖 p 的全部记录中选出一条, 与查询 p 内未被更具体网络覆盖的地址的结果一致
+func resolveNetwork(tree *iptrie.Tree[[]models.GeoIPV10], p netip.Prefix, policy Policy) models.GeoIPV10 {
+	var candidates []models.GeoIPV10
+	for _, entry := range tree.Supernets(p) {
+		candidates = append(candidates, entry.Value...)
+	}
+	return policy.Rank(candidates)[0].GeoIPV10
+}
+
+// writeFileAtomic 写入同目录下的临时文件后重命名, 读取方不会看到写了一半的文件
+func writeFileAtomic(path string, writer *mmdbwriter.Writer) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := writer.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mmdb 中表示 IPv4 的 ::/96, 以及它之后的第一个地址
+var (
+	mmdbIPv4Space = netip.MustParsePrefix("::/96")
+	mmdbIPv4After = netip.MustParseAddr("::1:0:0")
+)
+
+// verifyMMDB 重新打开导出的文件, 用每个网络的首地址查询并与导出的记录比对
+// city 布局通过 geoip2.Open 读取, flat 布局通过 maxminddb 读取
+func verifyMMDB(path string, opts MMDBExportOptions, chosen *iptrie.Tree[models.GeoIPV10]) (int, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("校验失败, 无法打开导出文件: %v", err)
+	}
+	defer db.Close()
+	if err := db.Verify(); err != nil {
+		return 0, fmt.Errorf("校验失败, 文件结构错误: %v", err)
+	}
+
+	var city *geoip2.Reader
+	if opts.Layout == MMDBLayoutCity {
+		if city, err = geoip2.Open(path); err != nil {
+			return 0, fmt.Errorf("校验失败, geoip2 无法打开导出文件: %v", err)
+		}
+		defer city.Close()
+	}
+
+	verified := 0
+	chosen.Walk(func(p netip.Prefix, _ models.GeoIPV10) bool {
+		addr := p.Addr()
+		if addr.Is6() && mmdbIPv4Space.Contains(addr) {
+			// ::/96 在 mmdb 中表示 IPv4, 改用包含它的网络在 ::/96 之后的第一个地址
+			addr = mmdbIPv4After
+		}
+		ip := net.IP(addr.AsSlice())
+		expected, want, _ := chosen.Lookup(addr)
+
+		var record map[string]any
+		network, ok, e := db.LookupNetwork(ip, &record)
+		switch {
+		case e != nil:
+			err = e
+		case !ok:
+			err = fmt.Errorf("未找到网络")
+		default:
+			ones, _ := network.Mask.Size()
+			if ones < expected.Bits() || !expected.Contains(netip.MustParseAddr(network.IP.String()).Unmap()) {
+				err = fmt.Errorf("网络不一致: %s, 期望位于 %s 内", network, expected)
+			}
+		}
+
+		if err == nil && city != nil {
+			var got *geoip2.City
+			if got, err = city.City(ip); err == nil {
+				if got.Country.IsoCode != want.CountryCode || localName(got.City.Names, opts.Locale) != want.City {
+					err = fmt.Errorf("city 记录不一致: %s/%s, 期望 %s/%s", got.Country.IsoCode, got.City.Names[opts.Locale], want.CountryCode, want.City)
+				}
+			}
+		}
+		if err == nil && city == nil && record["cidr"] != want.Cidr {
+			err = fmt.Errorf("flat 记录不一致: cidr %v, 期望 %s", record["cidr"], want.Cidr)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("校验失败 %s: %v", p, err)
+			return false
+		}
+		verified++
+		return true
+	})
+	if err == nil {
+		mlog.Info(mlog.H{"msg": "ExportMMDB: verified", "file": path, "networks": verified})
+	}
+	return verified, err
+}
+
+// mmdbCityRecord 生成兼容 GeoIP2-City 的记录, ASN 与 ISP 写入 traits (与 GeoIP2-Enterprise 一致)
+func mmdbCityRecord(g models.GeoIPV10, locale string) map[string]any {
+	extend, _ := g.GetExtendData()
+	text := func(key string) string {
+		v, _ := extend[key].(string)
+		return v
+	}
+	names := func(local, english string) map[string]any {
+		m := map[string]any{}
+		if english != "" {
+			m["en"] = english
+		}
+		if local != "" {
+			m[locale] = local
+		}
+		return m
+	}
+
+	record := map[string]any{}
+	put := func(m map[string]any, key string, v any) {
+		switch val := v.(type) {
+		case string:
+			if val == "" {
+				return
+			}
+		case map[string]any:
+			if len(val) == 0 {
+				return
+			}
+		}
+		m[key] = v
+	}
+
+	continent := map[string]any{}
+	put(continent, "code", text("continent_code"))
+	put(continent, "names", names(g.Continent, ""))
+	put(record, "continent", continent)
+
+	country := map[string]any{}
+	put(country, "iso_code", g.CountryCode)
+	put(country, "names", names(g.Country, g.CountryEnglish))
+	if v, _ := extend["is_in_european_union"].(bool); v {
+		country["is_in_european_union"] = true
+	}
+	put(record, "country", country)
+
+	registered := map[string]any{}
+	put(registered, "iso_code", text("registered_country_code"))
+	put(record, "registered_country", registered)
+
+	if g.Province != "" {
+		subdivision := map[string]any{"names": names(g.Province, "")}
+		put(subdivision, "iso_code", text("subdivision_code"))
+		record["subdivisions"] = []any{subdivision}
+	}
+
+	city := map[string]any{}
+	put(city, "names", names(g.City, ""))
+	put(record, "city", city)
+
+	location := map[string]any{}
+	if g.Latitude != 0 || g.Longitude != 0 {
+		location["latitude"], location["longitude"] = g.Latitude, g.Longitude
+	}
+	put(location, "time_zone", text("time_zone"))
+	if v, ok := extend["accuracy_radius"].(float64); ok && v > 0 && v <= 65535 {
+		location["accuracy_radius"] = uint16(v)
+	}
+	put(record, "location", location)
+
+	postal := map[string]any{}
+	put(postal, "code", text("postal_code"))
+	put(record, "postal", postal)
+
+	traits := map[string]any{}
+	if g.ASN > 0 {
+		traits["autonomous_system_number"] = uint32(g.ASN)
+	}
+	put(traits, "autonomous_system_organization", g.ASNOrg)
+	put(traits, "isp", g.ISP)
+	put(record, "traits", traits)
+	return record
+}
+
+// mmdbFlatRecord 生成与 GeoIPV10 json 标签一致的记录, 省略空值
+func mmdbFlatRecord(g models.GeoIPV10, _ string) map[string]any {
+	record := map[string]any{
+		"source":     g.Source,
+		"cidr":       g.Cidr,
+		"confidence": uint16(max(g.Confidence, 0)),
+	}
+	texts := map[string]string{
+		"isp": g.ISP, "eswn": g.ESWN, "continent": g.Continent, "country": g.Country,
+		"country_code": g.CountryCode, "country_english": g.CountryEnglish, "province": g.Province,
+		"city": g.City, "district": g.District, "asn_org": g.ASNOrg,
+	}
+	for k, v := range texts {
+		if v != "" {
+			record[k] = v
+		}
+	}
+	if g.AreaCode != 0 {
+		record["area_code"] = g.AreaCode
+	}
+	if g.ASN > 0 {
+		record["asn"] = uint32(g.ASN)
+	}
+	if g.Latitude != 0 || g.Longitude != 0 {
+		record["latitude"], record["longitude"] = g.Latitude, g.Longitude
+	}
+	if extend, err := g.GetExtendData(); err == nil && len(extend) > 0 {
+		if v := mmdbValue(extend); v != nil {
+			record["extend"] = v
+		}
+	}
+	return record
+}
+
+// mmdbValue 将 JSON 解码得到的值转换为 mmdb 可编码的类型, 无法表示的值 (null) 返回 nil
+func mmdbValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(val))
+		for k, item := range val {
+			if item := mmdbValue(item); item != nil {
+				m[k] = item
+			}
+		}
+		return m
+	case []any:
+		items := make([]any, 0, len(val))
+		for _, item := range val {
+			if item := mmdbValue(item); item != nil {
+				items = append(items, item)
+			}
+		}
+		return items
+	case string, bool, float64:
+		return val
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ImportMMDB 遍历 .mmdb 文件中的全部网络并写入导入器, paths 可以是文件或目录
+//
+// 第一个 City/Enterprise 数据库 (没有时取第一个文件) 作为主库遍历, 其余数据库 (如 ASN) 按网络首地址查询,
+// 仅当其网络覆盖整个主库网络时补全空字段; 无法映射到 GeoIPV10 的字段写入 extend
+func ImportMMDB(paths []string, confidence int, imp *models.GeoIPV10Importer) error {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			files = append(files, srvMMDB.findSuffixFile(path, ".mmdb")...)
+		} else {
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("未找到 .mmdb 文件")
+	}
+
+	readers := []*mmdbReader{}
+	defer func() {
+		for _, r := range readers {
+			r.reader.Close()
+		}
+	}()
+	for _, file := range files {
+		db, err := maxminddb.Open(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		mlog.Info(mlog.H{"msg": "ImportMMDB: open", "file": file, "type": db.Metadata.DatabaseType})
+		readers = append(readers, &mmdbReader{file: file, kind: mmdbKind(db.Metadata.DatabaseType), reader: db})
+	}
+
+	// 选择主库
+	primary := 0
+	for i, r := range readers {
+		if r.kind == mmdbTypeCity || r.kind == mmdbTypeEnterprise {
+			primary = i
+			break
+		}
+	}
+	base := readers[primary]
+	others := append(append([]*mmdbReader{}, readers[:primary]...), readers[primary+1:]...)
+
+	count := 0
+	networks := base.reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		count++
+		if count%100000 == 0 {
+			mlog.Info(mlog.H{"msg": "ImportMMDB: progress", "file": base.file, "processed": count, "inserted": imp.Stats.Inserted})
+		}
+
+		geoip := models.GeoIPV10{Confidence: confidence}
+		extend := map[string]interface{}{}
+		_, network, err := base.decode(func(record any) (*net.IPNet, bool, error) {
+			n, err := networks.Network(record)
+			return n, err == nil, err
+		}, &geoip, extend)
+		if err != nil {
+			mlog.Debug(mlog.H{"msg": "ImportMMDB: decode failed", "file": base.file, "err": err.Error()})
+			imp.Invalid()
+			continue
+		}
+
+		for _, r := range others {
+			var other models.GeoIPV10
+			otherExtend := map[string]interface{}{}
+			found, n, err := r.lookup(network.IP, &other, otherExtend)
+			if err != nil || !found || maskOnes(n) > maskOnes(network) {
+				continue
+			}
+			geoip.FillEmpty(other)
+			for k, v := range otherExtend {
+				setExtend(extend, k, v)
+			}
+		}
+
+		geoip.Cidr = network.String()
+		if err := geoip.SetExtendData(extend); err != nil {
+			mlog.Error(mlog.H{"msg": "ImportMMDB: failed to set extend data", "cidr": geoip.Cidr, "err": err.Error()})
+		}
+		if err := imp.Add(geoip); err != nil {
+			return err
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return fmt.Errorf("%s: %v", base.file, err)
+	}
+
+	mlog.Info(mlog.H{"msg": "ImportMMDB: completed", "file": base.file, "processed": count})
+	return nil
+}
@@ -0,0 +1,28 @@
+package geoip
+
+import "testing"
+
+func TestLocalName(t *testing.T) {
+	tests := []struct {
+		names  map[string]string
+		locale string
+		want   string
+	}{
+		{map[string]string{"zh-CN": "北京", "en": "Beijing"}, "zh-CN", "北京"},
+		{map[string]string{"zh-CN": "北京", "en": "Beijing"}, "ja", "Beijing"},
+		{map[string]string{"zh-CN": "", "en": "Beijing"}, "zh-CN", "Beijing"},
+		// 没有首选语言和英文时按语言代码排序取第一个非空名称
+		{map[string]string{"ru": "Пекин", "ja": "北京市", "de": "Peking", "fr": "Pékin"}, "zh-CN", "Peking"},
+		{map[string]string{"ru": "Пекин", "de": "", "fr": "Pékin"}, "zh-CN", "Pékin"},
+		{map[string]string{}, "zh-CN", ""},
+		{nil, "zh-CN", ""},
+	}
+	for _, tt := range tests {
+		// map 遍历顺序随机, 多次调用结果应一致
+		for range 20 {
+			if got := localName(tt.names, tt.locale); got != tt.want {
+				t.Fatalf("localName(%v, %s) = %q, want %q", tt.names, tt.locale, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package geoip
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+	"github.com/lwmacct/250402-m-geoip/internal/iptrie"
+	"gorm.io/gorm"
+)
+
+// 全局共享的前缀树服务, 查询与写入服务均引用此实例
+var srvTrie = new(SrvTrie)
+
+// SrvTrie 内存前缀树查询引擎, 数据从 geoip_v10 加载, 表变更时整体重建并原子替换
+type SrvTrie struct {
+	once   sync.Once
+	mu     sync.Mutex // 串行化重建
+	tree   atomic.Pointer[iptrie.Tree[[]models.GeoIPV10]]
+	stamp  string
+	notify chan struct{}
+}
+
+// Init 初始化服务, 启用时在后台加载数据并定期检查变更
+func (t *SrvTrie) Init() *SrvTrie {
+	t.once.Do(func() {
+		t.notify = make(chan struct{}, 1)
+		if !app.Flag.App.Trie.Enable {
+			mlog.Info(mlog.H{"msg": "内存前缀树未启用"})
+			return
+		}
+		go t.loop()
+	})
+	return t
+}
+
+// Ready 前缀树是否已加载完成
+func (t *SrvTrie) Ready() bool {
+	return t.tree.Load() != nil
+}
+
+// Notify 通知数据已变更, 尽快重建前缀树
+func (t *SrvTrie) Notify() {
+	if t.notify == nil {
+		return
+	}
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Matches 返回所有包含该地址的网络记录, 由调用方按裁决策略排序
+func (t *SrvTrie) Matches(addr netip.Addr) []models.GeoIPV10 {
+	tree := t.tree.Load()
+	if tree == nil {
+		return nil
+	}
+	var rows []models.GeoIPV10
+	for _, entry := range tree.Matches(addr) {
+		rows = append(rows, entry.Value...)
+	}
+	return rows
+}
+
+// Get 精确匹配CIDR, 返回该网络的全部来源记录
+func (t *SrvTrie) Get(prefix netip.Prefix) []models.GeoIPV10 {
+	tree := t.tree.Load()
+	if tree == nil {
+		return nil
+	}
+	rows, _ := tree.Get(prefix)
+	return rows
+}
+
+// Subnets 返回该网络包含的网络记录 (含自身, 排在最前), 收集到 limit 条后停止遍历, limit <= 0 表示不限
+func (t *SrvTrie) Subnets(prefix netip.Prefix, limit int) []models.GeoIPV10 {
+	tree := t.tree.Load()
+	if tree == nil {
+		return nil
+	}
+	var rows []models.GeoIPV10
+	tree.WalkSubnets(prefix, func(_ netip.Prefix, v []models.GeoIPV10) bool {
+		rows = append(rows, v...)
+		return limit <= 0 || len(rows) < limit
+	})
+	return rows
+}
+
+// Supernets 返回包含该网络的全部网络记录 (含自身)
+func (t *SrvTrie) Supernets(prefix netip.Prefix) []models.GeoIPV10 {
+	tree := t.tree.Load()
+	if tree == nil {
+		return nil
+	}
+	var rows []models.GeoIPV10
+	for _, entry := range tree.Supernets(prefix) {
+		rows = append(rows, entry.Value...)
+	}
+	return rows
+}
+
+// Snapshot 返回当前的前缀树, 未启用或尚未加载完成时返回 nil
+func (t *SrvTrie) Snapshot() *iptrie.Tree[[]models.GeoIPV10] {
+	return t.tree.Load()
+}
+
+// Reload 从数据库重新加载全部数据并原子替换前缀树
+func (t *SrvTrie) Reload() error {
+	return t.reload(true)
+}
+
+// reload 重建前缀树, force 为 false 时表指纹未变化则跳过
+func (t *SrvTrie) reload(force bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if app.DB == nil {
+		return fmt.Errorf("数据库连接未初始化")
+	}
+
+	stamp, err := t.fingerprint()
+	if err != nil {
+		return err
+	}
+	if !force && stamp == t.stamp && t.Ready() {
+		return nil
+	}
+
+	begin := time.Now()
+	tree, rows, err := loadTree(app.DB.Model(&models.GeoIPV10{}))
+	if err != nil {
+		return err
+	}
+
+	t.tree.Store(tree)
+	t.stamp = stamp
+	mlog.Info(mlog.H{"msg": "前缀树加载完成", "prefixes": tree.Len(), "rows": rows, "timeTaken": time.Since(begin).String()})
+	return nil
+}
+
+// loadTree 加载查询 tx 返回的记录, 同一网络的多个来源保存在同一节点
+func loadTree(tx *gorm.DB) (*iptrie.Tree[[]models.GeoIPV10], int64, error) {
+	tree := iptrie.New[[]models.GeoIPV10]()
+	var batch []models.GeoIPV10
+	result := tx.FindInBatches(&batch, 10000, func(tx *gorm.DB, _ int) error {
+		for _, row := range batch {
+			prefix, err := netip.ParsePrefix(row.Cidr)
+			if err != nil {
+				mlog.Warn(mlog.H{"msg": "前缀树跳过无效CIDR", "cidr": row.Cidr, "id": row.ID})
+				continue
+			}
+			row := row
+			tree.Update(prefix, func(old []models.GeoIPV10, _ bool) []models.GeoIPV10 {
+				return append(old, row)
+			})
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("加载前缀树数据失败: %v", result.Error)
+	}
+	return tree, result.RowsAffected, nil
+}
+
+// loop 首次加载后定期检查表指纹, 变化时重建
+func (t *SrvTrie) loop() {
+	if err := t.Reload(); err != nil {
+		mlog.Error(mlog.H{"msg": "前缀树加载失败", "err": err.Error()})
+	}
+
+	refresh := app.Flag.App.Trie.Refresh
+	if refresh <= 0 {
+		refresh = 30 * time.Second
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.notify:
+		}
+
+		if err := t.reload(false); err != nil {
+			mlog.Error(mlog.H{"msg": "前缀树重建失败", "err": err.Error()})
+		}
+	}
+}
+
+// fingerprint 计算数据表指纹, 用于判断是否需要重建
+func (t *SrvTrie) fingerprint() (string, error) {
+	var stat struct {
+		Total     int64
+		MaxID     int64
+		UpdatedAt sql.NullTime
+		DeletedAt sql.NullTime
+	}
+	err := app.DB.Raw(fmt.Sprintf(
+		"SELECT count(*) FILTER (WHERE deleted_at IS NULL) AS total, coalesce(max(id), 0) AS max_id, max(updated_at) AS updated_at, max(deleted_at) AS deleted_at FROM %s",
+		models.GeoIPV10{}.TableName(),
+	)).Scan(&stat).Error
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%d/%d/%d", stat.Total, stat.MaxID, stat.UpdatedAt.Time.UnixNano(), stat.DeletedAt.Time.UnixNano()), nil
+}
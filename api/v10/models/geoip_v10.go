@@ -1,21 +1,14 @@
 package models
 
 import (
-	"bufio"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
 
-	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
-	"github.com/lwmacct/250402-m-geoip/app"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 // GeoIPV10 IP地理位置信息，存储网络地址段的地理位置数据
@@ -71,177 +64,95 @@ func (g *GeoIPV10) GetExtendData() (map[string]interface{}, error) {
 	return data, nil
 }
 
-func (t GeoIPV10) InsertSampleData(db *gorm.DB) {
-	csvPath := os.Getenv("GOPKG_CSV_PATH_250402")
-	// 检查数据库连接
-	if db == nil {
-		mlog.Error(mlog.H{"msg": "InsertGeoIP: database connection not initialized"})
-		return
-	}
-
-	if csvPath == "" {
-		mlog.Error(mlog.H{"msg": "InsertGeoIP: GOPKG_CSV_PATH environment variable not set"})
-		return
-	}
-
-	// 打开CSV文件
-	file, err := os.Open(csvPath)
-	if err != nil {
-		mlog.Error(mlog.H{"msg": "InsertGeoIP: failed to open CSV file", "err": err})
-		return
-	}
-	defer file.Close()
-
-	// 计算总行数用于显示百分比
-	totalLines := 0
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		totalLines++
-	}
-	if err := scanner.Err(); err != nil {
-		mlog.Error(mlog.H{"msg": "InsertGeoIP: failed to count total lines", "err": err})
-	}
-
-	// 重新定位文件指针到开头
-	file.Seek(0, 0)
-
-	// 配置CSV读取器
-	reader := csv.NewReader(bufio.NewReader(file))
-	reader.TrimLeadingSpace = true
-
-	// 读取并解析表头
-	header, err := reader.Read()
-	if err != nil {
-		mlog.Error(mlog.H{"msg": "InsertGeoIP: failed to read CSV header", "err": err})
-		return
-	}
-
-	// 调整总行数（减去表头）
-	totalLines--
-
-	// 创建列名映射
-	headerMap := make(map[string]int)
-	for i, name := range header {
-		headerMap[strings.ToLower(name)] = i
-	}
-
-	// 验证必要字段
-	if _, ok := headerMap["cidr"]; !ok {
-		mlog.Error(mlog.H{"msg": "InsertGeoIP: required field 'cidr' not found in headers"})
-		return
-	}
-
-	// 设置导入参数
-	recordCount := 0
-	insertedCount := 0
-	batchSize := 1000
-	batch := make([]GeoIPV10, 0, batchSize)
-
-	// 逐行处理CSV数据
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+// FillEmpty 用 src 中的非空字段补全当前记录的空字段, 返回被补全的字段名 (json 标签)
+// source、cidr、confidence 描述记录本身, 不参与补全; extend 按键合并
+func (g *GeoIPV10) FillEmpty(src GeoIPV10) []string {
+	filled := []string{}
+
+	dst := reflect.ValueOf(g).Elem()
+	from := reflect.ValueOf(src)
+	typ := dst.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous || name == "" || name == "-" {
 			continue
 		}
-
-		recordCount++
-
-		// 处理CIDR字段
-		cidrIdx := headerMap["cidr"]
-		if cidrIdx >= len(record) || record[cidrIdx] == "" {
+		switch name {
+		case "source", "cidr", "confidence", "extend":
 			continue
 		}
-
-		cidr := record[cidrIdx]
-		// 创建GeoIP对象
-		geoip := GeoIPV10{
-			Source:         "csv_import",
-			Confidence:     80,
-			ISP:            extractFieldV2(record, headerMap, "isp", ""),
-			Cidr:           cidr,
-			ESWN:           extractFieldV2(record, headerMap, "eswn", ""),
-			Continent:      extractFieldV2(record, headerMap, "continent", ""),
-			Country:        extractFieldV2(record, headerMap, "country", ""),
-			CountryCode:    extractFieldV2(record, headerMap, "country_code", ""),
-			CountryEnglish: extractFieldV2(record, headerMap, "country_english", ""),
-			Province:       extractFieldV2(record, headerMap, "province", ""),
-			City:           extractFieldV2(record, headerMap, "city", ""),
-			District:       extractFieldV2(record, headerMap, "district", ""),
-			AreaCode:       extractFieldV2(record, headerMap, "area_code", 0),
-			Longitude:      extractFieldV2(record, headerMap, "longitude", 0.0),
-			Latitude:       extractFieldV2(record, headerMap, "latitude", 0.0),
-			ASN:            extractFieldV2(record, headerMap, "asn", 0),
-			ASNOrg:         extractFieldV2(record, headerMap, "asn_org", ""),
-		}
-
-		// 处理扩展字段
-		extendData := map[string]interface{}{}
-		standardFields := getModelFields(GeoIPV10{})
-		// 添加标准字段映射以便查询
-		standardFieldsMap := make(map[string]bool)
-		for _, field := range standardFields {
-			standardFieldsMap[field] = true
+		if dst.Field(i).IsZero() && !from.Field(i).IsZero() {
+			dst.Field(i).Set(from.Field(i))
+			filled = append(filled, name)
 		}
+	}
 
-		for key, idx := range headerMap {
-			// 排除已处理的标准字段
-			if !standardFieldsMap[key] {
-				if idx < len(record) && record[idx] != "" {
-					extendData[key] = record[idx]
+	// 合并扩展字段, 已有的键保持不变
+	if len(src.Extend) > 0 {
+		srcData, err1 := src.GetExtendData()
+		dstData, err2 := g.GetExtendData()
+		if err1 == nil && err2 == nil {
+			merged := false
+			for k, v := range srcData {
+				if _, ok := dstData[k]; !ok {
+					dstData[k] = v
+					merged = true
 				}
 			}
-		}
-
-		if len(extendData) > 0 {
-			if err := geoip.SetExtendData(extendData); err != nil {
-				mlog.Error(mlog.H{"msg": "InsertGeoIP: failed to set extend data", "err": err})
+			if merged && g.SetExtendData(dstData) == nil {
+				filled = append(filled, "extend")
 			}
 		}
+	}
+	return filled
+}
 
-		// 添加到批处理
-		batch = append(batch, geoip)
-
-		// 当达到批处理大小时执行批量插入
-		if len(batch) >= batchSize {
-			// 使用OnConflict-DoNothing子句，确保冲突的记录被跳过而不是中断整个批次
-			result := app.DB.Clauses(clause.OnConflict{
-				DoNothing: true,
-			}).CreateInBatches(batch, batchSize)
-
-			if result.Error != nil {
-				mlog.Error(mlog.H{"msg": "InsertGeoIP: batch insert error", "err": result.Error})
-			}
-			insertedCount += int(result.RowsAffected)
-
-			// 清空批处理数组
-			batch = make([]GeoIPV10, 0, batchSize)
-
-			// 每批次完成后记录进度
-			if recordCount%10000 == 0 {
-				percentage := float64(recordCount) / float64(totalLines) * 100
-				mlog.Info(mlog.H{"msg": "InsertGeoIP: progress", "processed": recordCount, "inserted": insertedCount, "percentage": fmt.Sprintf("%.2f%%", percentage)})
-			}
+// SetFields 按 json 标签设置字段, 返回无法映射的键值 (调用方通常写入 extend)
+// 数值字段接受任意数值类型, 类型不匹配的值视为无法映射; values 中的 extend 对象直接展开到返回值
+func (g *GeoIPV10) SetFields(values map[string]interface{}) map[string]interface{} {
+	rest := map[string]interface{}{}
+
+	dst := reflect.ValueOf(g).Elem()
+	typ := dst.Type()
+	index := map[string]int{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.Anonymous && name != "" && name != "-" && name != "extend" {
+			index[name] = i
 		}
 	}
 
-	// 处理最后一批数据
-	if len(batch) > 0 {
-		result := app.DB.Clauses(clause.OnConflict{
-			DoNothing: true,
-		}).CreateInBatches(batch, len(batch))
+	for k, v := range values {
+		if extend, ok := v.(map[string]interface{}); ok && k == "extend" {
+			for ek, ev := range extend {
+				rest[ek] = ev
+			}
+			continue
+		}
 
-		if result.Error != nil {
-			mlog.Error(mlog.H{"msg": "InsertGeoIP: final batch insert error", "err": result.Error})
+		i, ok := index[k]
+		if !ok || v == nil {
+			rest[k] = v
+			continue
+		}
+		field, val := dst.Field(i), reflect.ValueOf(v)
+		switch field.Kind() {
+		case reflect.String:
+			ok = val.Kind() == reflect.String
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			ok = val.CanInt() || val.CanUint() || val.CanFloat()
+		default:
+			ok = false
 		}
-		insertedCount += int(result.RowsAffected)
+		if !ok {
+			rest[k] = v
+			continue
+		}
+		field.Set(val.Convert(field.Type()))
 	}
-
-	// 更新统计信息
-	mlog.Info(mlog.H{"msg": "InsertGeoIP: completed", "processed": recordCount, "inserted": insertedCount, "percentage": "100.00%"})
-	app.DB.Exec(fmt.Sprintf("ANALYZE %s", GeoIPV10{}.TableName()))
+	return rest
 }
 
 // TableIndex 定义并创建表索引
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+)
+
+// CSVOptions CSV 导入选项
+type CSVOptions struct {
+	Confidence int               // 默认可信度, CSV 中存在 confidence 列时以列值为准
+	Columns    map[string]string // 字段名 -> CSV 列名, 覆盖默认的同名映射
+}
+
+// ImportCSV 读取带表头的 CSV 文件并写入导入器
+// 表头与 GeoIPV10 列名同名的列自动映射, 其余非空列写入 extend
+func ImportCSV(path string, opts CSVOptions, imp *GeoIPV10Importer) error {
+	// 打开CSV文件
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+
+	// 计算总行数用于显示百分比
+	totalLines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		totalLines++
+	}
+	if err := scanner.Err(); err != nil {
+		mlog.Error(mlog.H{"msg": "ImportCSV: failed to count total lines", "file": path, "err": err})
+	}
+
+	// 重新定位文件指针到开头
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// 配置CSV读取器
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	// 读取并解析表头
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	// 调整总行数（减去表头）
+	totalLines--
+
+	headerMap, extendMap, err := csvHeaderMap(header, opts.Columns)
+	if err != nil {
+		return err
+	}
+
+	// 逐行处理CSV数据
+	recordCount := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			imp.Invalid()
+			continue
+		}
+
+		recordCount++
+
+		// 处理CIDR字段, 支持 "起始IP-结束IP" 格式, 由导入器拆分
+		cidr := csvCidr(record, headerMap)
+		if cidr == "" {
+			imp.Invalid()
+			continue
+		}
+
+		// 创建GeoIP对象
+		geoip := GeoIPV10{
+			Confidence:     extractFieldV2(record, headerMap, "confidence", opts.Confidence),
+			ISP:            extractFieldV2(record, headerMap, "isp", ""),
+			Cidr:           cidr,
+			ESWN:           extractFieldV2(record, headerMap, "eswn", ""),
+			Continent:      extractFieldV2(record, headerMap, "continent", ""),
+			Country:        extractFieldV2(record, headerMap, "country", ""),
+			CountryCode:    extractFieldV2(record, headerMap, "country_code", ""),
+			CountryEnglish: extractFieldV2(record, headerMap, "country_english", ""),
+			Province:       extractFieldV2(record, headerMap, "province", ""),
+			City:           extractFieldV2(record, headerMap, "city", ""),
+			District:       extractFieldV2(record, headerMap, "district", ""),
+			AreaCode:       extractFieldV2(record, headerMap, "area_code", 0),
+			Longitude:      extractFieldV2(record, headerMap, "longitude", 0.0),
+			Latitude:       extractFieldV2(record, headerMap, "latitude", 0.0),
+			ASN:            extractFieldV2(record, headerMap, "asn", 0),
+			ASNOrg:         extractFieldV2(record, headerMap, "asn_org", ""),
+		}
+
+		// 处理扩展字段
+		extendData := map[string]interface{}{}
+		for key, idx := range extendMap {
+			if idx < len(record) && record[idx] != "" {
+				extendData[key] = record[idx]
+			}
+		}
+		if err := geoip.SetExtendData(extendData); err != nil {
+			mlog.Error(mlog.H{"msg": "ImportCSV: failed to set extend data", "err": err})
+		}
+
+		if err := imp.Add(geoip); err != nil {
+			return err
+		}
+
+		// 记录进度
+		if recordCount%10000 == 0 {
+			percentage := float64(recordCount) / float64(max(totalLines, 1)) * 100
+			mlog.Info(mlog.H{"msg": "ImportCSV: progress", "file": path, "processed": recordCount, "inserted": imp.Stats.Inserted, "percentage": fmt.Sprintf("%.2f%%", percentage)})
+		}
+	}
+
+	mlog.Info(mlog.H{"msg": "ImportCSV: completed", "file": path, "processed": recordCount, "percentage": "100.00%"})
+	return nil
+}
+
+// csvCidr 读取 cidr 列, 或由 start_ip 与 end_ip 列组成IP范围
+func csvCidr(record []string, headerMap map[string]int) string {
+	if idx, ok := headerMap["cidr"]; ok {
+		if idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+	start, end := headerMap["start_ip"], headerMap["end_ip"]
+	if start >= len(record) || end >= len(record) || record[start] == "" || record[end] == "" {
+		return ""
+	}
+	return strings.TrimSpace(record[start]) + "-" + strings.TrimSpace(record[end])
+}
+
+// csvHeaderMap 根据表头和列映射生成字段索引, 以及写入 extend 的列索引
+func csvHeaderMap(header []string, columns map[string]string) (map[string]int, map[string]int, error) {
+	// 创建列名映射
+	indexMap := make(map[string]int)
+	for i, name := range header {
+		indexMap[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	standardFieldsMap := make(map[string]bool)
+	for _, field := range getModelFields(GeoIPV10{}) {
+		standardFieldsMap[field] = true
+	}
+
+	headerMap := make(map[string]int)
+	for name, idx := range indexMap {
+		if standardFieldsMap[name] {
+			headerMap[name] = idx
+		}
+	}
+
+	// 应用列映射覆盖
+	for field, column := range columns {
+		field = strings.ToLower(field)
+		if !standardFieldsMap[field] {
+			return nil, nil, fmt.Errorf("列映射中的字段不存在: %s", field)
+		}
+		idx, ok := indexMap[strings.ToLower(column)]
+		if !ok {
+			return nil, nil, fmt.Errorf("列映射中的CSV列不存在: %s=%s", field, column)
+		}
+		headerMap[field] = idx
+	}
+
+	// 验证必要字段, 没有 cidr 列时使用 start_ip 与 end_ip 列组成IP范围
+	if _, ok := headerMap["cidr"]; !ok {
+		start, ok1 := indexMap["start_ip"]
+		end, ok2 := indexMap["end_ip"]
+		if !ok1 || !ok2 {
+			return nil, nil, fmt.Errorf("required field 'cidr' (or 'start_ip' and 'end_ip') not found in headers")
+		}
+		headerMap["start_ip"], headerMap["end_ip"] = start, end
+	}
+
+	// 未被标准字段使用的列写入 extend
+	used := make(map[int]bool)
+	for _, idx := range headerMap {
+		used[idx] = true
+	}
+	extendMap := make(map[string]int)
+	for name, idx := range indexMap {
+		if !used[idx] && !standardFieldsMap[name] {
+			extendMap[name] = idx
+		}
+	}
+	return headerMap, extendMap, nil
+}
@@ -0,0 +1,300 @@
+package models
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 导入模式
+const (
+	ImportModeInsert        = "insert"         // 已存在的 (source, cidr) 跳过
+	ImportModeUpsert        = "upsert"         // 已存在的 (source, cidr) 覆盖更新
+	ImportModeReplaceSource = "replace-source" // 暂存到影子表, 校验后整体替换该来源的数据
+)
+
+// ImportStats 导入统计
+type ImportStats struct {
+	Processed int `json:"processed"`
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Skipped   int `json:"skipped"`
+	Invalid   int `json:"invalid"`
+	Deleted   int `json:"deleted"` // replace-source 模式下被替换的旧记录数
+}
+
+// GeoIPV10Importer 按批写入 GeoIPV10 记录, 所有记录使用同一个来源名称
+//
+// replace-source 模式下记录先写入影子表, Close 时校验并在单个事务内替换线上数据,
+// 查询在替换完成前始终看到完整的旧数据
+type GeoIPV10Importer struct {
+	db        *gorm.DB
+	source    string
+	mode      string
+	batchSize int
+	batch     []GeoIPV10
+	MinRatio  float64          // replace-source 模式下新数据少于旧数据的该比例时放弃替换, 0 表示不检查
+	Release   *GeoIPV10Release // replace-source 模式下生成的发布记录
+	Stats     ImportStats
+}
+
+// NewGeoIPV10Importer 创建导入器
+func NewGeoIPV10Importer(db *gorm.DB, source, mode string) (*GeoIPV10Importer, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if source == "" || len(source) > 32 {
+		return nil, fmt.Errorf("source 不能为空且长度不能超过 32: %q", source)
+	}
+
+	t := &GeoIPV10Importer{db: db, source: source, mode: mode, batchSize: 1000}
+	switch mode {
+	case ImportModeInsert, ImportModeUpsert:
+	case ImportModeReplaceSource:
+		if err := EnsureReleaseTables(db); err != nil {
+			return nil, err
+		}
+		// 清理上次中断的导入残留
+		if err := clearStage(db, source); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的导入模式: %s", mode)
+	}
+	return t, nil
+}
+
+// Add 添加一条记录, 来源统一设置为导入器的来源, 无效记录计入 Invalid
+// cidr 为 "起始IP-结束IP" 格式时拆分为最少的 CIDR, 每个网络写入一条相同内容的记录
+func (t *GeoIPV10Importer) Add(row GeoIPV10) error {
+	t.Stats.Processed++
+	row.Source = t.source
+
+	if strings.Contains(row.Cidr, "-") {
+		r, err := cidrset.ParseRange(row.Cidr)
+		if err != nil {
+			t.Stats.Invalid++
+			mlog.Debug(mlog.H{"msg": "Import: invalid row", "cidr": row.Cidr, "err": err.Error()})
+			return nil
+		}
+		for _, prefix := range r.Prefixes() {
+			row.Cidr = prefix.String()
+			if err := t.add(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return t.add(row)
+}
+
+// add 校验并加入当前批次, 批次已满时写入
+func (t *GeoIPV10Importer) add(row GeoIPV10) error {
+	cidr, err := NormalizeCidr(row.Cidr)
+	if err == nil {
+		row.Cidr = cidr
+		err = row.Validate()
+	}
+	if err != nil {
+		t.Stats.Invalid++
+		mlog.Debug(mlog.H{"msg": "Import: invalid row", "cidr": row.Cidr, "err": err.Error()})
+		return nil
+	}
+
+	t.batch = append(t.batch, row)
+	if len(t.batch) >= t.batchSize {
+		return t.flush()
+	}
+	return nil
+}
+
+// Invalid 记录一条无法解析的输入
+func (t *GeoIPV10Importer) Invalid() {
+	t.Stats.Processed++
+	t.Stats.Invalid++
+}
+
+// Close 写入剩余数据, replace-source 模式下校验影子表并替换线上数据
+func (t *GeoIPV10Importer) Close() error {
+	if err := t.flush(); err != nil {
+		t.Abort()
+		return err
+	}
+	if t.mode == ImportModeReplaceSource {
+		release, deleted, err := swapStage(t.db, t.source, t.MinRatio)
+		if err != nil {
+			t.Abort()
+			return err
+		}
+		t.Release, t.Stats.Deleted = release, int(deleted)
+	}
+	t.db.Exec(fmt.Sprintf("ANALYZE %s", GeoIPV10{}.TableName()))
+	return nil
+}
+
+// Abort 放弃未写入的数据, replace-source 模式下同时清空影子表, 线上数据不受影响
+func (t *GeoIPV10Importer) Abort() {
+	t.batch = nil
+	if t.mode == ImportModeReplaceSource {
+		if err := clearStage(t.db, t.source); err != nil {
+			mlog.Error(mlog.H{"msg": "Import: failed to clear stage", "source": t.source, "err": err})
+		}
+	}
+}
+
+// flush 写入当前批次
+func (t *GeoIPV10Importer) flush() error {
+	if len(t.batch) == 0 {
+		return nil
+	}
+	batch := t.dedupe(t.batch)
+	t.batch = t.batch[:0]
+
+	if t.mode == ImportModeReplaceSource {
+		// 写入影子表, 跨批次重复的 cidr 由影子表唯一索引跳过
+		result := t.db.Table(stageTable()).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(batch, len(batch))
+		if result.Error != nil {
+			return fmt.Errorf("batch stage: %v", result.Error)
+		}
+		t.Stats.Inserted += int(result.RowsAffected)
+		t.Stats.Skipped += len(batch) - int(result.RowsAffected)
+		return nil
+	}
+
+	existing, err := t.existing(batch)
+	if err != nil {
+		return err
+	}
+
+	db := t.db
+	switch t.mode {
+	case ImportModeUpsert:
+		result := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "source"}, {Name: "cidr"}},
+			DoUpdates: clause.AssignmentColumns(append(importColumns(), "updated_at", "deleted_at")),
+		}).CreateInBatches(batch, len(batch))
+		if result.Error != nil {
+			return fmt.Errorf("batch upsert: %v", result.Error)
+		}
+		t.Stats.Updated += len(existing)
+		t.Stats.Inserted += len(batch) - len(existing)
+
+	default:
+		rows := make([]GeoIPV10, 0, len(batch))
+		for _, row := range batch {
+			if existing[row.Cidr] {
+				t.Stats.Skipped++
+				continue
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		// 使用OnConflict-DoNothing子句，确保并发写入冲突的记录被跳过而不是中断整个批次
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, len(rows))
+		if result.Error != nil {
+			return fmt.Errorf("batch insert: %v", result.Error)
+		}
+		t.Stats.Inserted += int(result.RowsAffected)
+		t.Stats.Skipped += len(rows) - int(result.RowsAffected)
+	}
+	return nil
+}
+
+// dedupe 批次内相同 cidr 只保留最后一条, 其余计入 Skipped
+func (t *GeoIPV10Importer) dedupe(batch []GeoIPV10) []GeoIPV10 {
+	index := make(map[string]int, len(batch))
+	rows := make([]GeoIPV10, 0, len(batch))
+	for _, row := range batch {
+		if i, ok := index[row.Cidr]; ok {
+			rows[i] = row
+			t.Stats.Skipped++
+			continue
+		}
+		index[row.Cidr] = len(rows)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// existing 返回批次中已存在于数据库的 cidr
+func (t *GeoIPV10Importer) existing(batch []GeoIPV10) (map[string]bool, error) {
+	cidrs := make([]string, 0, len(batch))
+	for _, row := range batch {
+		cidrs = append(cidrs, row.Cidr)
+	}
+
+	var found []string
+	err := t.db.Model(&GeoIPV10{}).Unscoped().
+		Where("source = ? AND cidr IN ?", t.source, cidrs).
+		Pluck("cidr", &found).Error
+	if err != nil {
+		return nil, fmt.Errorf("query existing rows: %v", err)
+	}
+
+	existing := make(map[string]bool, len(found))
+	for _, cidr := range found {
+		if normalized, err := NormalizeCidr(cidr); err == nil {
+			existing[normalized] = true
+		}
+	}
+	return existing, nil
+}
+
+// NormalizeCidr 校验CIDR并返回规范格式, 单个IP视为主机路由, 主机位不为0时报错
+func NormalizeCidr(cidr string) (string, error) {
+	if cidr == "" {
+		return cidr, fmt.Errorf("cidr 不能为空")
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		addr, err2 := netip.ParseAddr(cidr)
+		if err2 != nil {
+			return cidr, fmt.Errorf("无效的CIDR格式: %s", cidr)
+		}
+		addr = addr.Unmap()
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if prefix.Masked() != prefix {
+		return cidr, fmt.Errorf("CIDR 主机位不为0: %s, 应为 %s", cidr, prefix.Masked())
+	}
+	return prefix.String(), nil
+}
+
+// Validate 校验字段取值范围
+func (g *GeoIPV10) Validate() error {
+	if g.Confidence < 0 || g.Confidence > 100 {
+		return fmt.Errorf("confidence 必须在 0-100 之间: %d", g.Confidence)
+	}
+	if g.Latitude < -90 || g.Latitude > 90 {
+		return fmt.Errorf("latitude 必须在 -90 到 90 之间: %v", g.Latitude)
+	}
+	if g.Longitude < -180 || g.Longitude > 180 {
+		return fmt.Errorf("longitude 必须在 -180 到 180 之间: %v", g.Longitude)
+	}
+	if g.ASN < 0 {
+		return fmt.Errorf("asn 不能为负数: %d", g.ASN)
+	}
+	if len(g.Extend) > 0 {
+		if _, err := g.GetExtendData(); err != nil {
+			return fmt.Errorf("extend 必须是 JSON 对象: %v", err)
+		}
+	}
+	return nil
+}
+
+// importColumns 导入时可覆盖的数据列, 不含唯一键 (source, cidr)
+func importColumns() []string {
+	return []string{
+		"extend", "confidence", "isp", "eswn", "continent",
+		"country", "country_code", "country_english", "province", "city",
+		"district", "area_code", "latitude", "longitude", "asn", "asn_org",
+	}
+}
@@ -0,0 +1,374 @@
+package models
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/internal/cidrset"
+	"github.com/lwmacct/250402-m-geoip/internal/iptrie"
+)
+
+// MaxMindCSVOptions MaxMind CSV 数据包导入选项
+type MaxMindCSVOptions struct {
+	Locale     string // Country、Province、City 使用的语言, 如 zh-CN, 缺失时回退到英文
+	Confidence int
+}
+
+// maxmindFiles MaxMind CSV 数据包中的文件, 按用途分类
+type maxmindFiles struct {
+	blocks    []string          // City/Country 的 Blocks-IPv4/IPv6
+	asn       []string          // ASN 的 Blocks-IPv4/IPv6
+	locations map[string]string // 语言 -> Locations 文件
+}
+
+// maxmindLocation Locations 文件中的一行
+type maxmindLocation struct {
+	ContinentCode string
+	Continent     string
+	CountryCode   string
+	Country       string
+	Province      string
+	ProvinceCode  string
+	City          string
+	TimeZone      string
+	EU            bool
+}
+
+// maxmindASN ASN Blocks 文件中的一行
+type maxmindASN struct {
+	Number int
+	Org    string
+}
+
+// ImportMaxMindCSV 导入 MaxMind GeoLite2/GeoIP2 CSV 数据包
+//
+// paths 可以是解压后的目录或单个文件, City/Country 的 Blocks 文件通过 geoname_id 关联 Locations 文件,
+// Blocks 网络按 ASN 数据包中网络的边界拆分, 各部分写入对应的 asn/asn_org; 只有 ASN 数据包时直接导入 ASN 网络
+func ImportMaxMindCSV(paths []string, opts MaxMindCSVOptions, imp *GeoIPV10Importer) error {
+	files, err := findMaxMindFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files.blocks) == 0 && len(files.asn) == 0 {
+		return fmt.Errorf("未找到 MaxMind CSV 文件 (*-Blocks-IPv4.csv, *-Blocks-IPv6.csv)")
+	}
+
+	asn := iptrie.New[maxmindASN]()
+	for _, file := range files.asn {
+		if err := readMaxMindASN(file, asn); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	if len(files.blocks) == 0 {
+		mlog.Info(mlog.H{"msg": "ImportMaxMindCSV: ASN only", "networks": asn.Len()})
+		return importMaxMindASN(asn, opts, imp)
+	}
+
+	if opts.Locale == "" {
+		opts.Locale = "en"
+	}
+	english, err := readMaxMindLocations(files.locations["en"])
+	if err != nil {
+		return err
+	}
+	local := english
+	if opts.Locale != "en" {
+		if files.locations[opts.Locale] == "" {
+			mlog.Warn(mlog.H{"msg": "ImportMaxMindCSV: locale not found, fallback to en", "locale": opts.Locale})
+		} else if local, err = readMaxMindLocations(files.locations[opts.Locale]); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files.blocks {
+		if err := readMaxMindBlocks(file, local, english, asn, opts, imp); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// findMaxMindFiles 在目录中按文件名识别 Blocks、ASN 和 Locations 文件
+func findMaxMindFiles(paths []string) (maxmindFiles, error) {
+	files := maxmindFiles{locations: map[string]string{}}
+	classify := func(path string) {
+		name := filepath.Base(path)
+		switch {
+		case !strings.HasSuffix(name, ".csv"):
+		case strings.Contains(name, "-ASN-Blocks-IPv"):
+			files.asn = append(files.asn, path)
+		case strings.Contains(name, "-Blocks-IPv"):
+			files.blocks = append(files.blocks, path)
+		case strings.Contains(name, "-Locations-"):
+			locale := strings.TrimSuffix(name[strings.Index(name, "-Locations-")+len("-Locations-"):], ".csv")
+			files.locations[locale] = path
+		}
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return files, err
+		}
+		if !info.IsDir() {
+			classify(path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				classify(p)
+			}
+			return err
+		})
+		if err != nil {
+			return files, err
+		}
+	}
+
+	if len(files.blocks) > 0 && files.locations["en"] == "" {
+		return files, fmt.Errorf("未找到 Locations-en.csv, 无法关联 geoname_id")
+	}
+	return files, nil
+}
+
+// readMaxMindCSV 逐行读取带表头的 CSV, fn 的参数为列名到值的访问函数
+func readMaxMindCSV(path string, fn func(get func(string) string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+
+	var record []string
+	get := func(name string) string {
+		if i, ok := index[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	for {
+		record, err = reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(get); err != nil {
+			return err
+		}
+	}
+}
+
+// readMaxMindLocations 读取 Locations 文件, 返回 geoname_id 到位置的映射
+func readMaxMindLocations(path string) (map[string]maxmindLocation, error) {
+	locations := map[string]maxmindLocation{}
+	err := readMaxMindCSV(path, func(get func(string) string) error {
+		locations[get("geoname_id")] = maxmindLocation{
+			ContinentCode: get("continent_code"),
+			Continent:     get("continent_name"),
+			CountryCode:   get("country_iso_code"),
+			Country:       get("country_name"),
+			Province:      get("subdivision_1_name"),
+			ProvinceCode:  get("subdivision_1_iso_code"),
+			City:          get("city_name"),
+			TimeZone:      get("time_zone"),
+			EU:            get("is_in_european_union") == "1",
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	mlog.Info(mlog.H{"msg": "ImportMaxMindCSV: locations loaded", "file": path, "count": len(locations)})
+	return locations, nil
+}
+
+// readMaxMindASN 读取 ASN Blocks 文件到前缀树
+func readMaxMindASN(path string, tree *iptrie.Tree[maxmindASN]) error {
+	err := readMaxMindCSV(path, func(get func(string) string) error {
+		prefix, err := netip.ParsePrefix(get("network"))
+		if err != nil {
+			return nil
+		}
+		number, _ := strconv.Atoi(get("autonomous_system_number"))
+		tree.Insert(prefix, maxmindASN{Number: number, Org: get("autonomous_system_organization")})
+		return nil
+	})
+	mlog.Info(mlog.H{"msg": "ImportMaxMindCSV: asn loaded", "file": path, "networks": tree.Len()})
+	return err
+}
+
+// importMaxMindASN 只有 ASN 数据包时, 每个 ASN 网络导入为一条记录
+func importMaxMindASN(tree *iptrie.Tree[maxmindASN], opts MaxMindCSVOptions, imp *GeoIPV10Importer) error {
+	var err error
+	tree.Walk(func(p netip.Prefix, v maxmindASN) bool {
+		err = imp.Add(GeoIPV10{Cidr: p.String(), Confidence: opts.Confidence, ASN: v.Number, ASNOrg: v.Org})
+		return err == nil
+	})
+	return err
+}
+
+// readMaxMindBlocks 读取 Blocks 文件, 关联位置和 ASN 后写入导入器
+func readMaxMindBlocks(path string, local, english map[string]maxmindLocation, asn *iptrie.Tree[maxmindASN], opts MaxMindCSVOptions, imp *GeoIPV10Importer) error {
+	count := 0
+	err := readMaxMindCSV(path, func(get func(string) string) error {
+		count++
+		if count%100000 == 0 {
+			mlog.Info(mlog.H{"msg": "ImportMaxMindCSV: progress", "file": path, "processed": count, "inserted": imp.Stats.Inserted})
+		}
+
+		prefix, err := netip.ParsePrefix(get("network"))
+		if err != nil {
+			imp.Invalid()
+			return nil
+		}
+
+		// 没有 geoname_id 的网络只能定位到注册国家
+		id := get("geoname_id")
+		if id == "" {
+			id = get("registered_country_geoname_id")
+		}
+		loc, ok := local[id]
+		if !ok {
+			loc = english[id]
+		}
+		en := english[id]
+
+		geoip := GeoIPV10{
+			Cidr:           prefix.String(),
+			Confidence:     opts.Confidence,
+			Continent:      firstNonEmpty(loc.Continent, en.Continent),
+			Country:        firstNonEmpty(loc.Country, en.Country),
+			CountryCode:    en.CountryCode,
+			CountryEnglish: en.Country,
+			Province:       firstNonEmpty(loc.Province, en.Province),
+			City:           firstNonEmpty(loc.City, en.City),
+		}
+		geoip.Latitude, _ = strconv.ParseFloat(get("latitude"), 64)
+		geoip.Longitude, _ = strconv.ParseFloat(get("longitude"), 64)
+
+		extend := map[string]interface{}{}
+		setMaxMindExtend(extend, "geoname_id", id)
+		setMaxMindExtend(extend, "continent_code", en.ContinentCode)
+		setMaxMindExtend(extend, "subdivision_code", en.ProvinceCode)
+		setMaxMindExtend(extend, "postal_code", get("postal_code"))
+		setMaxMindExtend(extend, "time_zone", en.TimeZone)
+		if radius, err := strconv.Atoi(get("accuracy_radius")); err == nil {
+			extend["accuracy_radius"] = radius
+		}
+		if registered, ok := english[get("registered_country_geoname_id")]; ok {
+			setMaxMindExtend(extend, "registered_country_code", registered.CountryCode)
+		}
+		if represented, ok := english[get("represented_country_geoname_id")]; ok {
+			setMaxMindExtend(extend, "represented_country_code", represented.CountryCode)
+		}
+		if en.EU {
+			extend["is_in_european_union"] = true
+		}
+		for _, key := range []string{"is_anonymous_proxy", "is_satellite_provider", "is_anycast"} {
+			if get(key) == "1" {
+				extend[key] = true
+			}
+		}
+		if err := geoip.SetExtendData(extend); err != nil {
+			mlog.Error(mlog.H{"msg": "ImportMaxMindCSV: failed to set extend data", "err": err})
+		}
+
+		// 网络内部拆分给多个 ASN 时按 ASN 网络的边界拆分为多条记录
+		for _, piece := range splitMaxMindASN(prefix, asn) {
+			geoip.Cidr, geoip.ASN, geoip.ASNOrg = piece.Prefix.String(), piece.Value.Number, piece.Value.Org
+			if err := imp.Add(geoip); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	mlog.Info(mlog.H{"msg": "ImportMaxMindCSV: completed", "file": path, "processed": count})
+	return err
+}
+
+// splitMaxMindASN 按 ASN 网络的边界拆分网络, 每部分取最具体的 ASN, 不属于任何 ASN 网络的部分 ASN 为空
+// 结果按地址排序, 网络不跨越 ASN 边界时只有一部分
+func splitMaxMindASN(prefix netip.Prefix, asn *iptrie.Tree[maxmindASN]) []iptrie.Entry[maxmindASN] {
+	var cover maxmindASN
+	if matches := asn.Supernets(prefix); len(matches) > 0 {
+		cover = matches[0].Value
+	}
+	// 前缀树中的网络已清除主机位, IPv4-mapped 网络按 IPv4 保存
+	self := cidrset.New(prefix)
+	base := self.Prefixes()[0]
+	var inner []iptrie.Entry[maxmindASN]
+	for _, entry := range asn.Subnets(prefix) {
+		if entry.Prefix != base {
+			inner = append(inner, entry)
+		}
+	}
+	if len(inner) == 0 {
+		return []iptrie.Entry[maxmindASN]{{Prefix: prefix, Value: cover}}
+	}
+
+	// 每个 ASN 网络扣除其内部更具体的 ASN 网络, 剩余部分使用覆盖整个网络的 ASN
+	var pieces []iptrie.Entry[maxmindASN]
+	add := func(set *cidrset.Set, value maxmindASN) {
+		for _, p := range set.Prefixes() {
+			pieces = append(pieces, iptrie.Entry[maxmindASN]{Prefix: p, Value: value})
+		}
+	}
+	all := make([]netip.Prefix, 0, len(inner))
+	for _, entry := range inner {
+		all = append(all, entry.Prefix)
+		var nested []netip.Prefix
+		for _, sub := range asn.Subnets(entry.Prefix) {
+			if sub.Prefix != entry.Prefix {
+				nested = append(nested, sub.Prefix)
+			}
+		}
+		add(cidrset.New(entry.Prefix).Subtract(cidrset.New(nested...)), entry.Value)
+	}
+	add(self.Subtract(cidrset.New(all...)), cover)
+
+	slices.SortFunc(pieces, func(a, b iptrie.Entry[maxmindASN]) int {
+		return a.Prefix.Addr().Compare(b.Prefix.Addr())
+	})
+	return pieces
+}
+
+// setMaxMindExtend 仅写入非空字符串到扩展字段
+func setMaxMindExtend(extend map[string]interface{}, key, v string) {
+	if v != "" {
+		extend[key] = v
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/lwmacct/250402-m-geoip/internal/iptrie"
+)
+
+func TestSplitMaxMindASN(t *testing.T) {
+	asn := iptrie.New[maxmindASN]()
+	for p, number := range map[string]int{
+		"1.0.0.0/8":          100,
+		"2.0.0.0/24":         200,
+		"2.0.1.0/24":         201,
+		"3.0.0.0/16":         300,
+		"3.0.128.0/17":       301,
+		"2001:db8::/33":      400,
+		"2001:db8:4000::/34": 401,
+	} {
+		asn.Insert(netip.MustParsePrefix(p), maxmindASN{Number: number, Org: fmt.Sprintf("AS%d", number)})
+	}
+
+	tests := []struct {
+		block string
+		want  []string // 网络=ASN, 0 表示没有 ASN
+	}{
+		// ASN 网络覆盖整个 Blocks 网络
+		{"1.2.3.0/24", []string{"1.2.3.0/24=100"}},
+		{"1.0.0.0/8", []string{"1.0.0.0/8=100"}},
+		{"9.0.0.0/8", []string{"9.0.0.0/8=0"}},
+		// Blocks 网络比覆盖它的 ASN 网络更粗, 按 ASN 边界拆分, 未覆盖的部分没有 ASN
+		{"2.0.0.0/23", []string{"2.0.0.0/24=200", "2.0.1.0/24=201"}},
+		{"2.0.0.0/22", []string{"2.0.0.0/24=200", "2.0.1.0/24=201", "2.0.2.0/23=0"}},
+		// 嵌套的 ASN 网络取最具体的
+		{"3.0.0.0/15", []string{"3.0.0.0/17=300", "3.0.128.0/17=301", "3.1.0.0/16=0"}},
+		{"3.0.0.0/16", []string{"3.0.0.0/17=300", "3.0.128.0/17=301"}},
+		{"2001:db8::/32", []string{"2001:db8::/34=400", "2001:db8:4000::/34=401", "2001:db8:8000::/33=0"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, piece := range splitMaxMindASN(netip.MustParsePrefix(tt.block), asn) {
+			if piece.Value.Number != 0 && piece.Value.Org != fmt.Sprintf("AS%d", piece.Value.Number) {
+				t.Errorf("%s: org = %q", piece.Prefix, piece.Value.Org)
+			}
+			got = append(got, fmt.Sprintf("%s=%d", piece.Prefix, piece.Value.Number))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitMaxMindASN(%s) = %v, want %v", tt.block, got, tt.want)
+		}
+	}
+}
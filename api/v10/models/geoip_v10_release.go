@@ -0,0 +1,286 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"gorm.io/gorm"
+)
+
+// 发布动作
+const (
+	ReleaseActionReplace  = "replace"
+	ReleaseActionRollback = "rollback"
+)
+
+// GeoIPV10Release 按来源整体替换数据的发布记录
+type GeoIPV10Release struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	Source    string    `json:"source" gorm:"type:varchar(32);index;column:source;comment:数据来源"`
+	Version   int64     `json:"version" gorm:"type:bigint;column:version;comment:发布后生效的数据版本"`
+	Previous  int64     `json:"previous" gorm:"type:bigint;column:previous;comment:被替换的数据版本, 保存在历史表中用于回滚"`
+	Action    string    `json:"action" gorm:"type:varchar(16);column:action;comment:replace 或 rollback"`
+	Rows      int64     `json:"rows" gorm:"type:bigint;column:rows;comment:生效的记录数"`
+}
+
+// TableName 指定表名
+func (GeoIPV10Release) TableName() string {
+	return GeoIPV10{}.TableName() + "_release"
+}
+
+// stageTable 暂存新数据的影子表
+func stageTable() string {
+	return GeoIPV10{}.TableName() + "_stage"
+}
+
+// historyTable 保存被替换数据的历史表
+func historyTable() string {
+	return GeoIPV10{}.TableName() + "_history"
+}
+
+// EnsureReleaseTables 创建发布记录表、影子表和历史表
+func EnsureReleaseTables(db *gorm.DB) error {
+	if err := db.AutoMigrate(&GeoIPV10Release{}); err != nil {
+		return err
+	}
+
+	name := GeoIPV10{}.TableName()
+	stage, history := stageTable(), historyTable()
+	sqls := []string{
+		// 影子表只用于导入过程, 不需要写 WAL
+		fmt.Sprintf("CREATE UNLOGGED TABLE IF NOT EXISTS %s (LIKE %s INCLUDING DEFAULTS)", stage, name),
+		fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS idx_%s_source_network ON %s (source, cidr)", stage, stage),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING DEFAULTS, version bigint NOT NULL DEFAULT 0)", history, name),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_source_version ON %s (source, version)", history, history),
+	}
+	for _, sql := range sqls {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+
+	// LIKE 只在建表时复制列, 之后线上表新增或修改的列需要同步, 否则复制数据时列不一致
+	for _, table := range []string{stage, history} {
+		if err := syncColumns(db, name, table); err != nil {
+			return fmt.Errorf("同步 %s 的列失败: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// column 表的列及其类型
+type column struct {
+	Name string
+	Type string
+}
+
+// tableColumns 返回表的全部列, 类型为 format_type 的格式
+func tableColumns(db *gorm.DB, table string) ([]column, error) {
+	var columns []column
+	err := db.Raw(`SELECT attname AS name, format_type(atttypid, atttypmod) AS type FROM pg_attribute
+		WHERE attrelid = ?::regclass AND attnum > 0 AND NOT attisdropped ORDER BY attnum`, table).Scan(&columns).Error
+	return columns, err
+}
+
+// syncColumns 为 table 补齐线上表 from 中新增的列, 并同步类型变化的列
+func syncColumns(db *gorm.DB, from, table string) error {
+	want, err := tableColumns(db, from)
+	if err != nil {
+		return err
+	}
+	existing, err := tableColumns(db, table)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]string, len(existing))
+	for _, c := range existing {
+		have[c.Name] = c.Type
+	}
+
+	for _, c := range want {
+		var sql string
+		switch typ, ok := have[c.Name]; {
+		case !ok:
+			sql = fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %q %s`, table, c.Name, c.Type)
+		case typ != c.Type:
+			sql = fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %q TYPE %s USING %q::%s`, table, c.Name, c.Type, c.Name, c.Type)
+		default:
+			continue
+		}
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+		mlog.Info(mlog.H{"msg": "Release: column synced", "table": table, "column": c.Name, "type": c.Type})
+	}
+	return nil
+}
+
+// CurrentVersion 返回来源当前生效的数据版本, 未发布过时为 0
+func CurrentVersion(db *gorm.DB, source string) (int64, error) {
+	var release GeoIPV10Release
+	err := db.Where("source = ?", source).Order("id DESC").Limit(1).Find(&release).Error
+	return release.Version, err
+}
+
+// nextVersion 返回来源下一次替换使用的版本, 取发布记录中的最大版本加 1
+// 回滚会把生效版本改回旧版本, 按当前版本加 1 会与已发布的版本重复
+func nextVersion(db *gorm.DB, source string) (int64, error) {
+	var version int64
+	err := db.Model(&GeoIPV10Release{}).Where("source = ?", source).
+		Select("coalesce(max(version), 0)").Scan(&version).Error
+	return version + 1, err
+}
+
+// ListReleases 返回来源的发布记录, 最新的在前
+func ListReleases(db *gorm.DB, source string, limit int) ([]GeoIPV10Release, error) {
+	var releases []GeoIPV10Release
+	tx := db.Order("id DESC").Limit(limit)
+	if source != "" {
+		tx = tx.Where("source = ?", source)
+	}
+	err := tx.Find(&releases).Error
+	return releases, err
+}
+
+// clearStage 清空影子表中该来源的数据
+func clearStage(db *gorm.DB, source string) error {
+	return db.Exec(fmt.Sprintf("DELETE FROM %s WHERE source = ?", stageTable()), source).Error
+}
+
+// swapStage 校验影子表数据后, 在同一事务内替换该来源的线上数据
+// 旧数据移入历史表, 历史表中每个来源只保留上一个版本
+func swapStage(db *gorm.DB, source string, minRatio float64) (*GeoIPV10Release, int64, error) {
+	name, stage, history := GeoIPV10{}.TableName(), stageTable(), historyTable()
+	columns := strings.Join(releaseColumns(), ", ")
+
+	var staged, live int64
+	if err := db.Table(stage).Where("source = ?", source).Count(&staged).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := db.Model(&GeoIPV10{}).Where("source = ?", source).Count(&live).Error; err != nil {
+		return nil, 0, err
+	}
+
+	// 校验影子表数据
+	if staged == 0 {
+		return nil, 0, fmt.Errorf("影子表中没有来源 %s 的有效数据, 放弃替换", source)
+	}
+	if minRatio > 0 && live > 0 && float64(staged) < float64(live)*minRatio {
+		return nil, 0, fmt.Errorf("新数据 %d 条, 少于现有 %d 条的 %.0f%%, 放弃替换", staged, live, minRatio*100)
+	}
+
+	release := &GeoIPV10Release{Source: source, Action: ReleaseActionReplace, Rows: staged}
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 同一来源的替换串行执行
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", name+":"+source).Error; err != nil {
+			return err
+		}
+
+		current, err := CurrentVersion(tx, source)
+		if err != nil {
+			return err
+		}
+		next, err := nextVersion(tx, source)
+		if err != nil {
+			return err
+		}
+		release.Previous, release.Version = current, next
+
+		steps := []struct {
+			sql  string
+			args []any
+		}{
+			{fmt.Sprintf("DELETE FROM %s WHERE source = ?", history), []any{source}},
+			{fmt.Sprintf("INSERT INTO %s (%s, version) SELECT %s, ? FROM %s WHERE source = ?", history, columns, columns, name), []any{current, source}},
+			{fmt.Sprintf("DELETE FROM %s WHERE source = ?", name), []any{source}},
+			{fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE source = ? AND deleted_at IS NULL", name, columns, columns, stage), []any{source}},
+			{fmt.Sprintf("DELETE FROM %s WHERE source = ?", stage), []any{source}},
+		}
+		for i, step := range steps {
+			result := tx.Exec(step.sql, step.args...)
+			if result.Error != nil {
+				return result.Error
+			}
+			if i == 2 {
+				deleted = result.RowsAffected
+			}
+		}
+		return tx.Create(release).Error
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("替换来源 %s 失败: %v", source, err)
+	}
+
+	mlog.Info(mlog.H{"msg": "Release: source replaced", "source": source, "version": release.Version, "rows": staged, "deleted": deleted})
+	return release, deleted, nil
+}
+
+// RollbackSource 将来源的线上数据与历史表中的上一版本互换
+// 再次执行可撤销回滚
+func RollbackSource(db *gorm.DB, source string) (*GeoIPV10Release, error) {
+	if err := EnsureReleaseTables(db); err != nil {
+		return nil, err
+	}
+
+	name, history := GeoIPV10{}.TableName(), historyTable()
+	columns := strings.Join(releaseColumns(), ", ")
+
+	release := &GeoIPV10Release{Source: source, Action: ReleaseActionRollback}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", name+":"+source).Error; err != nil {
+			return err
+		}
+
+		var previous struct {
+			Version int64
+			Total   int64
+		}
+		if err := tx.Raw(fmt.Sprintf("SELECT coalesce(max(version), -1) AS version, count(*) AS total FROM %s WHERE source = ?", history), source).
+			Scan(&previous).Error; err != nil {
+			return err
+		}
+		if previous.Total == 0 {
+			return fmt.Errorf("来源 %s 没有可回滚的历史版本", source)
+		}
+
+		current, err := CurrentVersion(tx, source)
+		if err != nil {
+			return err
+		}
+		release.Previous, release.Version, release.Rows = current, previous.Version, previous.Total
+
+		// 线上数据与历史数据互换, 借助临时表避免两次插入互相覆盖
+		tmp := fmt.Sprintf("%s_swap", history)
+		steps := []struct {
+			sql  string
+			args []any
+		}{
+			{fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WHERE source = ?", tmp, columns, name), []any{source}},
+			{fmt.Sprintf("DELETE FROM %s WHERE source = ?", name), []any{source}},
+			{fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE source = ?", name, columns, columns, history), []any{source}},
+			{fmt.Sprintf("DELETE FROM %s WHERE source = ?", history), []any{source}},
+			{fmt.Sprintf("INSERT INTO %s (%s, version) SELECT %s, ? FROM %s", history, columns, columns, tmp), []any{current}},
+		}
+		for _, step := range steps {
+			if err := tx.Exec(step.sql, step.args...).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(release).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mlog.Info(mlog.H{"msg": "Release: source rolled back", "source": source, "version": release.Version, "rows": release.Rows})
+	return release, nil
+}
+
+// releaseColumns 在线上表、影子表和历史表之间复制的列, 主键由各表自行生成
+func releaseColumns() []string {
+	return append([]string{"source", "cidr", "created_at", "updated_at", "deleted_at"}, importColumns()...)
+}
@@ -0,0 +1,276 @@
+// Package whois 实现 WHOIS 协议 (RFC 3912) 的地理位置查询服务
+//
+// 单次查询: 发送一行以空格分隔的 IP/CIDR/IP范围, 返回 key: value 文本后关闭连接;
+// 批量查询: 第一行为 begin, 之后每行一个或多个输入, end 结束, 与 Team Cymru 的批量模式相同;
+// -v 或 verbose 输出全部字段, noverbose 恢复默认字段
+package whois
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+)
+
+// 单行长度上限, 以及批量模式下每次合并查询的条数
+const (
+	maxLine   = 4096
+	batchSize = 1000
+)
+
+// Server WHOIS 查询服务, 与 HTTP 接口共用同一个查询服务
+type Server struct {
+	query    *geoip.SrvDBQuery
+	timeout  time.Duration
+	maxItems int
+}
+
+// options 查询选项
+type options struct {
+	verbose bool
+}
+
+// New 创建服务, timeout 为连接空闲超时, maxItems 为单个连接最多查询的条数, 0 表示不限
+func New(query *geoip.SrvDBQuery, timeout time.Duration, maxItems int) *Server {
+	return &Server{query: query, timeout: timeout, maxItems: maxItems}
+}
+
+// Serve 在监听器上接受连接, 监听器关闭时返回 nil
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle 处理一个连接, 查询完成后关闭
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, maxLine)
+	writer := bufio.NewWriter(conn)
+	defer writer.Flush()
+
+	line, err := s.readLine(conn, reader)
+	if err != nil && line == "" {
+		return
+	}
+	if !strings.EqualFold(line, "begin") {
+		opt, inputs := parseLine(line, options{})
+		if len(inputs) == 0 {
+			fmt.Fprintf(writer, "%% error: %s: 请输入 IP、CIDR 或 IP范围\r\n", geoip.ErrInvalidInput)
+			return
+		}
+		if s.maxItems > 0 && len(inputs) > s.maxItems {
+			fmt.Fprintf(writer, "%% error: limit_exceeded: 超过单次查询的条数上限 %d\r\n", s.maxItems)
+			return
+		}
+		s.lookup(writer, inputs, opt)
+		return
+	}
+
+	fmt.Fprintf(writer, "%% bulk mode; one IP/CIDR/range per line, \"end\" to finish\r\n")
+	var (
+		opt   options
+		batch []string
+		items int
+	)
+	for {
+		line, err := s.readLine(conn, reader)
+		if strings.EqualFold(line, "end") {
+			break
+		}
+		next, inputs := parseLine(line, opt)
+		if next != opt {
+			// 选项变化前先输出已有的输入
+			s.lookup(writer, batch, opt)
+			batch, opt = nil, next
+		}
+		if items += len(inputs); s.maxItems > 0 && items > s.maxItems {
+			s.lookup(writer, batch, opt)
+			fmt.Fprintf(writer, "%% error: limit_exceeded: 超过单次查询的条数上限 %d\r\n", s.maxItems)
+			return
+		}
+		batch = append(batch, inputs...)
+		if len(batch) >= batchSize {
+			s.lookup(writer, batch, opt)
+			batch = nil
+			if writer.Flush() != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				mlog.Debug(mlog.H{"msg": "whois 读取失败", "remote": conn.RemoteAddr().String(), "err": err.Error()})
+			}
+			break
+		}
+	}
+	s.lookup(writer, batch, opt)
+}
+
+// readLine 读取一行并去除首尾空白, 每次读取前刷新空闲超时
+func (s *Server) readLine(conn net.Conn, reader *bufio.Reader) (string, error) {
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("单行超过 %d 字节", maxLine)
+	}
+	return strings.TrimSpace(string(line)), err
+}
+
+// parseLine 拆分一行中的选项和输入
+func parseLine(line string, opt options) (options, []string) {
+	var inputs []string
+	for _, field := range strings.Fields(line) {
+		switch strings.ToLower(field) {
+		case "-v", "verbose":
+			opt.verbose = true
+		case "noverbose":
+			opt.verbose = false
+		default:
+			inputs = append(inputs, field)
+		}
+	}
+	return opt, inputs
+}
+
+// lookup 批量查询并按输入顺序输出, IP范围拆分为多个网络分别输出
+// CIDR 按 containing 模式查询, 与 whois 返回所在网络的习惯一致
+func (s *Server) lookup(w io.Writer, inputs []string, opt options) {
+	if len(inputs) == 0 {
+		return
+	}
+
+	type item struct {
+		input string
+		err   error
+	}
+	var items []item
+	var lookups []string
+	for _, input := range inputs {
+		if !geoip.IsRange(input) {
+			items = append(items, item{input: input})
+			lookups = append(lookups, input)
+			continue
+		}
+		prefixes, err := geoip.RangePrefixes(input)
+		if err != nil {
+			items = append(items, item{input: input, err: err})
+			continue
+		}
+		for _, prefix := range prefixes {
+			items = append(items, item{input: prefix.String()})
+			lookups = append(lookups, prefix.String())
+		}
+	}
+
+	results, errs := s.query.LookupBatch(lookups, geoip.LookupOptions{Policy: geoip.DefaultPolicy(), Mode: geoip.CIDRModeContaining})
+	for _, it := range items {
+		if it.err != nil {
+			writeError(w, it.input, it.err)
+			continue
+		}
+		result, err := results[0], errs[0]
+		results, errs = results[1:], errs[1:]
+		if err != nil {
+			writeError(w, it.input, err)
+			continue
+		}
+		writeRecord(w, it.input, result.Record, opt)
+	}
+}
+
+// field 输出的字段
+type field struct {
+	key     string
+	verbose bool // 只在 verbose 时输出
+	value   func(models.GeoIPV10) string
+}
+
+var fields = []field{
+	{"cidr", false, func(g models.GeoIPV10) string { return g.Cidr }},
+	{"country_code", false, func(g models.GeoIPV10) string { return g.CountryCode }},
+	{"country", false, func(g models.GeoIPV10) string { return g.Country }},
+	{"country_english", true, func(g models.GeoIPV10) string { return g.CountryEnglish }},
+	{"continent", true, func(g models.GeoIPV10) string { return g.Continent }},
+	{"province", false, func(g models.GeoIPV10) string { return g.Province }},
+	{"city", false, func(g models.GeoIPV10) string { return g.City }},
+	{"district", true, func(g models.GeoIPV10) string { return g.District }},
+	{"area_code", true, func(g models.GeoIPV10) string { return formatInt(g.AreaCode) }},
+	{"latitude", true, func(g models.GeoIPV10) string { return formatFloat(g.Latitude) }},
+	{"longitude", true, func(g models.GeoIPV10) string { return formatFloat(g.Longitude) }},
+	{"isp", false, func(g models.GeoIPV10) string { return g.ISP }},
+	{"asn", false, func(g models.GeoIPV10) string { return formatInt(g.ASN) }},
+	{"asn_org", false, func(g models.GeoIPV10) string { return g.ASNOrg }},
+	{"source", true, func(g models.GeoIPV10) string { return g.Source }},
+	{"confidence", true, func(g models.GeoIPV10) string { return strconv.Itoa(g.Confidence) }},
+}
+
+// writeRecord 以 key: value 格式输出一条结果, 空字段不输出, 记录之间以空行分隔
+func writeRecord(w io.Writer, input string, g models.GeoIPV10, opt options) {
+	writeLine(w, "ip", input)
+	for _, f := range fields {
+		if f.verbose && !opt.verbose {
+			continue
+		}
+		if v := f.value(g); v != "" {
+			writeLine(w, f.key, v)
+		}
+	}
+	if opt.verbose {
+		extend, _ := g.GetExtendData()
+		keys := make([]string, 0, len(extend))
+		for k := range extend {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeLine(w, "extend."+k, fmt.Sprint(extend[k]))
+		}
+	}
+	fmt.Fprint(w, "\r\n")
+}
+
+// writeError 输出查询失败的结果
+func writeError(w io.Writer, input string, err error) {
+	code := geoip.ErrorCode(err)
+	writeLine(w, "ip", input)
+	writeLine(w, "error", code)
+	writeLine(w, "message", strings.TrimPrefix(err.Error(), code+": "))
+	fmt.Fprint(w, "\r\n")
+}
+
+func writeLine(w io.Writer, key, value string) {
+	fmt.Fprintf(w, "%-16s %s\r\n", key+":", strings.NewReplacer("\r", " ", "\n", " ").Replace(value))
+}
+
+func formatInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+func formatFloat(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
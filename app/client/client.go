@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 输出格式
+const (
+	FormatTable  = "table"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// columnsAll 输出全部字段
+const columnsAll = "all"
+
+// 与接口返回的错误码一致
+const (
+	statusError  = "error"
+	errorBackend = "backend_error"
+)
+
+// response 接口的响应, 结果保持接口返回的原始字段
+type response struct {
+	Code int                          `json:"code"`
+	Msg  string                       `json:"msg"`
+	Data []map[string]json.RawMessage `json:"data"`
+}
+
+// api HTTP 接口客户端
+type api struct {
+	endpoint string
+	client   *http.Client
+}
+
+// newAPI 创建客户端, query 为附加在每次请求上的查询参数
+func newAPI(addr, query string, timeout time.Duration) (*api, error) {
+	u, err := url.Parse(strings.TrimRight(addr, "/") + "/api/v10/geoip")
+	if err != nil {
+		return nil, fmt.Errorf("无效的接口地址: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("无效的接口地址: %s, 需要以 http:// 或 https:// 开头", addr)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("无效的查询参数: %v", err)
+	}
+	u.RawQuery = values.Encode()
+	return &api{endpoint: u.String(), client: &http.Client{Timeout: timeout}}, nil
+}
+
+// lookup 使用 POST 数组批量查询, 部分条目为后端错误时接口仍返回结果
+// class 等筛选参数会过滤结果, 返回条数可能少于请求条数
+func (t *api) lookup(inputs []string) ([]map[string]json.RawMessage, error) {
+	body, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析响应失败 (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if len(result.Data) == 0 && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("请求失败 (HTTP %d): %s", resp.StatusCode, result.Msg)
+	}
+	return result.Data, nil
+}
+
+// readInputs 逐行读取输入, 每行可以有多个以逗号或空白分隔的条目, 忽略空行和 # 开头的注释行
+func readInputs(r io.Reader, fn func(input string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, input := range splitInput(line) {
+			if err := fn(input); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func splitInput(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// writer 按格式输出结果
+type writer struct {
+	format  string
+	columns []string
+	out     io.Writer
+	table   *tabwriter.Writer
+	csv     *csv.Writer
+	rows    int
+}
+
+func newWriter(out io.Writer, format string, columns []string) (*writer, error) {
+	all := len(columns) == 1 && columns[0] == columnsAll
+	w := &writer{format: format, columns: columns, out: out}
+	switch format {
+	case FormatJSON, FormatNDJSON:
+		if all {
+			w.columns = nil
+		}
+	case FormatTable, FormatCSV:
+		if all {
+			return nil, fmt.Errorf("%s 格式需要指定输出的字段", format)
+		}
+		if format == FormatTable {
+			w.table = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w.table, strings.ToUpper(strings.Join(columns, "\t")))
+		} else {
+			w.csv = csv.NewWriter(out)
+			if err := w.csv.Write(columns); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("不支持的输出格式: %s, 可选 %s", format,
+			strings.Join([]string{FormatTable, FormatJSON, FormatNDJSON, FormatCSV}, ", "))
+	}
+	return w, nil
+}
+
+// write 输出一条结果
+func (t *writer) write(row map[string]json.RawMessage) error {
+	defer func() { t.rows++ }()
+	switch t.format {
+	case FormatTable:
+		_, err := fmt.Fprintln(t.table, strings.Join(t.values(row), "\t"))
+		return err
+	case FormatCSV:
+		return t.csv.Write(t.values(row))
+	}
+
+	prefix := ""
+	if t.format == FormatJSON {
+		prefix = ",\n  "
+		if t.rows == 0 {
+			prefix = "[\n  "
+		}
+	}
+	line, err := t.object(row)
+	if err != nil {
+		return err
+	}
+	if t.format == FormatNDJSON {
+		_, err = fmt.Fprintf(t.out, "%s\n", line)
+	} else {
+		_, err = fmt.Fprintf(t.out, "%s%s", prefix, line)
+	}
+	return err
+}
+
+// close 结束输出
+func (t *writer) close() error {
+	switch t.format {
+	case FormatTable:
+		return t.table.Flush()
+	case FormatCSV:
+		t.csv.Flush()
+		return t.csv.Error()
+	case FormatJSON:
+		if t.rows == 0 {
+			_, err := fmt.Fprintln(t.out, "[]")
+			return err
+		}
+		_, err := fmt.Fprintln(t.out, "\n]")
+		return err
+	}
+	return nil
+}
+
+// object 按字段顺序输出 json 对象, 未指定字段时输出全部字段
+func (t *writer) object(row map[string]json.RawMessage) ([]byte, error) {
+	if t.columns == nil {
+		return json.Marshal(row)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range t.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		buf.Write(key)
+		buf.WriteByte(':')
+		if v, ok := row[column]; ok {
+			buf.Write(v)
+		} else {
+			buf.WriteString("null")
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// values 按字段顺序取出文本值, 字符串去掉引号, 对象和数组保持 json
+func (t *writer) values(row map[string]json.RawMessage) []string {
+	values := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		values[i] = text(row[column])
+	}
+	return values
+}
+
+func text(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// isBackendError 结果是否为后端错误
+func isBackendError(row map[string]json.RawMessage) bool {
+	return text(row["status"]) == statusError && text(row["error"]) == errorBackend
+}
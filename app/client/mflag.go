@@ -1,6 +1,11 @@
 package client
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
 	"github.com/lwmacct/250402-m-geoip/app"
 
 	"github.com/lwmacct/250300-go-mod-mflag/pkg/mflag"
@@ -12,13 +17,125 @@ func Cmd() *mflag.Ts {
 	mc := mflag.New(app.Flag).UsePackageName("")
 	mc.AddCmd(func(cmd *cobra.Command, args []string) {
 		run(cmd, args)
-	}, "run", "", "app", "mlog", "client")
+	}, "run", "通过 HTTP 接口查询, 参数为 IP/CIDR/范围, 未指定参数和 --client-file 时从标准输入读取; 存在后端错误时退出码为 2", "app", "mlog", "client")
 	return mc
 }
 
 func run(cmd *cobra.Command, args []string) {
 	_ = map[string]any{"cmd": cmd, "args": args}
-	mlog.Info(mlog.H{"msg": "app.Flag", "data": app.Flag})
+	cfg := app.Flag.Client
+
+	var columns []string
+	for _, column := range cfg.Columns {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		exit(fmt.Errorf("需要指定输出的字段"))
+	}
+	if cfg.ChunkSize <= 0 {
+		exit(fmt.Errorf("每次请求的条数需要大于 0"))
+	}
+	client, err := newAPI(cfg.Addr, cfg.Query, cfg.Timeout)
+	if err != nil {
+		exit(err)
+	}
+
+	out := os.Stdout
+	if cfg.Output != "" {
+		if out, err = os.Create(cfg.Output); err != nil {
+			exit(err)
+		}
+		defer out.Close()
+	}
+	w, err := newWriter(out, cfg.Format, columns)
+	if err != nil {
+		exit(err)
+	}
+
+	var (
+		chunk  []string
+		total  int
+		failed int
+	)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		rows, err := client.lookup(chunk)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if isBackendError(row) {
+				failed++
+			}
+			if err := w.write(row); err != nil {
+				return err
+			}
+		}
+		total += len(rows)
+		chunk = chunk[:0]
+		return nil
+	}
+	add := func(input string) error {
+		if chunk = append(chunk, input); len(chunk) >= cfg.ChunkSize {
+			return flush()
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		for _, input := range splitInput(arg) {
+			if err := add(input); err != nil {
+				exit(err)
+			}
+		}
+	}
+	if in, err := input(cfg.File, len(args) > 0); err != nil {
+		exit(err)
+	} else if in != nil {
+		err := readInputs(in, add)
+		in.Close()
+		if err != nil {
+			exit(err)
+		}
+	}
+	if err := flush(); err != nil {
+		exit(err)
+	}
+	if err := w.close(); err != nil {
+		exit(err)
+	}
+
+	mlog.Close()
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d/%d 条后端错误\n", failed, total)
+		os.Exit(2)
+	}
+}
+
+// input 返回需要读取的输入, 指定文件时读取文件, 没有参数且标准输入不是终端时读取标准输入
+func input(file string, hasArgs bool) (io.ReadCloser, error) {
+	switch file {
+	case "-":
+		return os.Stdin, nil
+	case "":
+		if hasArgs {
+			return nil, nil
+		}
+		if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			return nil, fmt.Errorf("需要指定 IP 参数、--client-file 或从标准输入读取")
+		}
+		return os.Stdin, nil
+	}
+	return os.Open(file)
+}
 
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	mlog.Error(mlog.H{"msg": "client failed", "err": err.Error()})
 	mlog.Close()
+	os.Exit(1)
 }
@@ -0,0 +1,106 @@
+package export
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lwmacct/250402-m-geoip/api"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+	"github.com/lwmacct/250402-m-geoip/app"
+
+	"github.com/lwmacct/250300-go-mod-mflag/pkg/mflag"
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/spf13/cobra"
+)
+
+func Cmd() *mflag.Ts {
+	mc := mflag.New(app.Flag)
+	mc.SetName("export", "导出IP地理位置数据")
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runMMDB(cmd, args)
+	}, "mmdb", "导出为 MaxMind DB (.mmdb) 文件, 参数为输出路径, 每个网络按 --app-policy-name 选出一条记录", "app", "mlog", "export")
+
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runList(cmd, args)
+	}, "list", "按条件导出聚合后的 CIDR 列表, 参数为输出路径, 未指定时输出到标准输出", "app", "mlog", "export")
+	return mc
+}
+
+func runList(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+	cfg := app.Flag.Export
+	if err := geoip.ValidExportFormat(cfg.Format, cfg.Name); err != nil {
+		exit(err)
+	}
+	connect()
+
+	srv := new(geoip.SrvDBExport).Init()
+	prefixes, err := srv.List(geoip.NetworkFilter{
+		CountryCode:   cfg.CountryCode,
+		Province:      cfg.Province,
+		City:          cfg.City,
+		ASN:           cfg.ASN,
+		ISP:           cfg.ISP,
+		Source:        cfg.Source,
+		MinConfidence: cfg.MinConfidence,
+		Family:        cfg.Family,
+	})
+	if err != nil {
+		exit(err)
+	}
+
+	out := os.Stdout
+	if len(args) > 0 {
+		if out, err = os.Create(args[0]); err != nil {
+			exit(err)
+		}
+		defer out.Close()
+	}
+	if err := srv.Render(out, cfg.Format, cfg.Name, prefixes); err != nil {
+		exit(err)
+	}
+	mlog.Close()
+}
+
+func runMMDB(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+	if len(args) != 1 {
+		exit(fmt.Errorf("需要指定一个输出文件"))
+	}
+	connect()
+
+	opts := geoip.MMDBExportOptions{
+		Layout:       app.Flag.Export.Layout,
+		DatabaseType: app.Flag.Export.Type,
+		Locale:       app.Flag.Export.Locale,
+		Policy:       geoip.DefaultPolicy(),
+		Verify:       app.Flag.Export.Verify,
+	}
+	stats, err := geoip.ExportMMDB(args[0], opts)
+	if err != nil {
+		exit(err)
+	}
+	fmt.Printf("File:        %s\n", args[0])
+	fmt.Printf("Layout:      %s\n", opts.Layout)
+	fmt.Printf("Networks:    %d\n", stats.Networks)
+	fmt.Printf("Records:     %d\n", stats.Records)
+	if opts.Verify {
+		fmt.Printf("Verified:    %d\n", stats.Verified)
+	}
+	mlog.Close()
+}
+
+// connect 连接数据库
+func connect() {
+	api.New().InitDb(app.Flag.App.DSN.PGSQL)
+	if app.DB == nil {
+		exit(fmt.Errorf("数据库连接失败"))
+	}
+}
+
+func exit(err error) {
+	fmt.Println(err)
+	mlog.Error(mlog.H{"msg": "export failed", "err": err.Error()})
+	mlog.Close()
+	os.Exit(1)
+}
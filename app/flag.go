@@ -1,6 +1,10 @@
 package app
 
-import "github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+import (
+	"time"
+
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+)
 
 type TsFlag struct {
 	Label []string `group:"app" note:"标签, 可用于区分不同的服务" default:""`
@@ -14,13 +18,95 @@ type TsFlag struct {
 		DSN struct {
 			PGSQL string `group:"app" note:"Postgresql 数据库连接字符串" default:""`
 		}
+
+		Trie struct {
+			Enable  bool          `group:"app" note:"启用内存前缀树查询, 启动时从数据库加载" default:"true"`
+			Refresh time.Duration `group:"app" note:"检查数据表变更并重建前缀树的间隔" default:"30s"`
+		}
+
+		MMDB struct {
+			Dir        string `group:"app" note:"MaxMind 数据库目录, 为空时读取环境变量 GOPKG_MMDB_DIR" default:""`
+			Mode       string `group:"app" note:"MaxMind 查询模式: off 不使用, fallback 数据库未命中时使用, merge 同时补全数据库结果中的空字段" default:"fallback"`
+			Locale     string `group:"app" note:"MaxMind 名称字段优先使用的语言" default:"zh-CN"`
+			Confidence int    `group:"app" note:"MaxMind 数据的可信度(0-100)" default:"50"`
+		}
+
+		Proxy struct {
+			Trusted []string `group:"app" note:"可信代理的 CIDR 或 IP, 只采用来自这些地址的转发请求头, 为空时不信任任何转发请求头" default:""`
+			Headers []string `group:"app" note:"客户端IP请求头, 按优先级排列, 可选 X-Forwarded-For, X-Real-IP, Forwarded, CF-Connecting-IP" default:"X-Forwarded-For,X-Real-IP,Forwarded,CF-Connecting-IP"`
+		}
+
+		ProxyProtocol struct {
+			Enable   bool          `group:"app" note:"监听端口接受 HAProxy PROXY protocol v1/v2 头部" default:"false"`
+			Upstream []string      `group:"app" note:"允许发送 PROXY protocol 头部的上游 CIDR 或 IP, 其他连接按普通连接处理" default:""`
+			Timeout  time.Duration `group:"app" note:"读取 PROXY protocol 头部的超时时间" default:"5s"`
+		}
+
+		DNS struct {
+			Enable     bool          `group:"app" note:"启用 DNS TXT 查询服务, 同时监听 UDP 与 TCP" default:"false"`
+			ListenAddr string        `group:"app" note:"DNS 监听地址" default:"0.0.0.0:5353"`
+			Zone       string        `group:"app" note:"DNS 查询的域名, 如 4.3.2.1.origin.geo.local" default:"geo.local"`
+			TTL        time.Duration `group:"app" note:"TXT 记录的有效期" default:"5m"`
+		}
+
+		Bulk struct {
+			Workers int `group:"app" note:"批量查询的并发数" default:"8"`
+		}
+
+		Stream struct {
+			MaxItems int `group:"app" note:"流式查询每个请求最多处理的条数, 请求参数 limit 只能调低" default:"1000000"`
+		}
+
+		Policy struct {
+			Name             string   `group:"app" note:"重叠网络裁决策略: most_specific, confidence, source_priority, weighted" default:"most_specific"`
+			Sources          []string `group:"app" note:"来源优先级列表, 越靠前越优先" default:""`
+			WeightPrefix     float64  `group:"app" note:"weighted 策略中前缀长度的权重" default:"0.5"`
+			WeightConfidence float64  `group:"app" note:"weighted 策略中可信度的权重" default:"0.3"`
+			WeightSource     float64  `group:"app" note:"weighted 策略中来源优先级的权重" default:"0.2"`
+		}
+	}
+
+	Import struct {
+		Source     string            `group:"import" note:"数据来源名称, 写入 source 字段" default:"csv_import"`
+		Confidence int               `group:"import" note:"默认可信度(0-100), CSV 中存在 confidence 列时以列值为准" default:"80"`
+		Mode       string            `group:"import" note:"导入模式: insert 跳过已存在, upsert 覆盖已存在, replace-source 替换该来源全部数据" default:"insert"`
+		Column     map[string]string `group:"import" note:"列名映射, 字段名=CSV列名, 如 cidr=network,asn=as_number" default:""`
+		Locale     string            `group:"import" note:"MaxMind CSV 导入时 country/province/city 使用的语言, country_english 固定使用 en" default:"zh-CN"`
+		MinRatio   float64           `group:"import" note:"replace-source 模式下新数据少于现有数据的该比例时放弃替换, 0 表示不检查" default:"0"`
+	}
+
+	Export struct {
+		Layout string `group:"export" note:"mmdb 记录布局: city 兼容 GeoIP2-City, flat 与 GeoIPV10 的 json 字段一致" default:"city"`
+		Type   string `group:"export" note:"mmdb 的 database_type, 为空时 city 使用 GeoIP2-City, flat 使用 GeoIPV10" default:""`
+		Locale string `group:"export" note:"city 布局中 country/province/city 名称使用的语言" default:"zh-CN"`
+		Verify bool   `group:"export" note:"写入后重新打开文件逐个网络校验" default:"true"`
+
+		Format        string   `group:"export" note:"CIDR 列表格式: plain, nginx, ipset, nftables, bind, range" default:"plain"`
+		Name          string   `group:"export" note:"nginx 变量、ipset/nftables 集合或 BIND acl 的名称" default:"geoip"`
+		CountryCode   []string `group:"export" note:"按国家代码筛选, 如 CN,HK" default:""`
+		Province      []string `group:"export" note:"按省份筛选" default:""`
+		City          []string `group:"export" note:"按城市筛选" default:""`
+		ASN           []int    `group:"export" note:"按自治系统编号筛选" default:""`
+		ISP           []string `group:"export" note:"按运营商筛选" default:""`
+		Source        []string `group:"export" note:"按数据来源筛选" default:""`
+		MinConfidence int      `group:"export" note:"只导出可信度不低于该值的记录" default:"0"`
+		Family        int      `group:"export" note:"只导出 IPv4 (4) 或 IPv6 (6), 0 表示不限" default:"0"`
 	}
 
 	Server struct {
-		ListenAddr string `group:"server" note:"监听地址" default:"0.0.0.0:8888"`
+		ListenAddr string        `group:"server" note:"WHOIS 监听地址" default:"0.0.0.0:43"`
+		Timeout    time.Duration `group:"server" note:"连接空闲超时" default:"30s"`
+		MaxItems   int           `group:"server" note:"单个连接最多查询的条数, 0 表示不限" default:"100000"`
 	}
 
 	Client struct {
-		ListenAddr string `group:"client" note:"监听地址" default:"0.0.0.0:8888"`
+		Addr      string        `group:"client" note:"HTTP 接口地址" default:"http://127.0.0.1:12119"`
+		File      string        `group:"client" note:"从文件读取输入, 每行一个或多个 IP/CIDR/范围, - 表示标准输入" default:""`
+		Format    string        `group:"client" note:"输出格式: table, json, ndjson, csv" default:"table"`
+		Columns   []string      `group:"client" note:"输出的字段, 与接口返回的 json 字段一致, all 表示全部字段 (仅 json/ndjson)" default:"ip,cidr,country_code,country,province,city,isp,asn,error"`
+		Query     string        `group:"client" note:"附加的查询参数, 如 policy=confidence&class=global" default:""`
+		ChunkSize int           `group:"client" note:"每次请求的条数" default:"1000"`
+		Timeout   time.Duration `group:"client" note:"单次请求超时" default:"60s"`
+		Output    string        `group:"client" note:"输出文件, 为空时输出到标准输出" default:""`
 	}
 }
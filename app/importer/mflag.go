@@ -0,0 +1,194 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lwmacct/250402-m-geoip/api"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+	"github.com/lwmacct/250402-m-geoip/api/v10/models"
+	"github.com/lwmacct/250402-m-geoip/app"
+
+	"github.com/lwmacct/250300-go-mod-mflag/pkg/mflag"
+	"github.com/lwmacct/250300-go-mod-mlog/pkg/mlog"
+	"github.com/spf13/cobra"
+)
+
+func Cmd() *mflag.Ts {
+	mc := mflag.New(app.Flag)
+	mc.SetName("import", "导入IP地理位置数据")
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runCSV(cmd, args)
+	}, "csv", "导入CSV文件, 参数为文件路径, 未指定时读取环境变量 GOPKG_CSV_PATH_250402", "app", "mlog", "import")
+
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runMaxMind(cmd, args)
+	}, "maxmind", "导入 MaxMind GeoLite2/GeoIP2 CSV 数据包, 参数为解压后的目录或文件, 可同时指定 City 与 ASN 数据包", "app", "mlog", "import")
+
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runMMDB(cmd, args)
+	}, "mmdb", "导入 .mmdb 文件中的全部网络, 参数为文件或目录, 多个文件时以 City 库为主并用其余库补全字段", "app", "mlog", "import")
+
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runRollback(cmd, args)
+	}, "rollback", "将 --import-source 的线上数据与上一个版本互换, 再次执行可撤销", "app", "mlog", "import")
+
+	mc.AddCmd(func(cmd *cobra.Command, args []string) {
+		runReleases(cmd, args)
+	}, "releases", "列出 replace-source 和 rollback 的发布记录", "app", "mlog", "import")
+	return mc
+}
+
+func runCSV(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+
+	files := args
+	if len(files) == 0 && os.Getenv("GOPKG_CSV_PATH_250402") != "" {
+		files = []string{os.Getenv("GOPKG_CSV_PATH_250402")}
+	}
+	if len(files) == 0 {
+		exit(fmt.Errorf("未指定CSV文件"))
+	}
+
+	imp := open()
+	opts := models.CSVOptions{
+		Confidence: app.Flag.Import.Confidence,
+		Columns:    app.Flag.Import.Column,
+	}
+	for _, file := range files {
+		if err := models.ImportCSV(file, opts, imp); err != nil {
+			imp.Abort()
+			exit(fmt.Errorf("%s: %v", file, err))
+		}
+	}
+	finish(imp)
+}
+
+func runMaxMind(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+	if len(args) == 0 {
+		exit(fmt.Errorf("未指定 MaxMind CSV 目录"))
+	}
+
+	maxmindDefaults(cmd)
+	imp := open()
+	opts := models.MaxMindCSVOptions{
+		Locale:     app.Flag.Import.Locale,
+		Confidence: app.Flag.Import.Confidence,
+	}
+	if err := models.ImportMaxMindCSV(args, opts, imp); err != nil {
+		imp.Abort()
+		exit(err)
+	}
+	finish(imp)
+}
+
+func runMMDB(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+	if len(args) == 0 {
+		exit(fmt.Errorf("未指定 .mmdb 文件"))
+	}
+
+	maxmindDefaults(cmd)
+	imp := open()
+	if err := geoip.ImportMMDB(args, app.Flag.Import.Confidence, imp); err != nil {
+		imp.Abort()
+		exit(err)
+	}
+	finish(imp)
+}
+
+// maxmindDefaults 未指定时使用与在线查询一致的来源名称和可信度
+func maxmindDefaults(cmd *cobra.Command) {
+	if !cmd.Flags().Changed("source") {
+		app.Flag.Import.Source = "maxmind"
+	}
+	if !cmd.Flags().Changed("confidence") {
+		app.Flag.Import.Confidence = app.Flag.App.MMDB.Confidence
+	}
+}
+
+// open 连接数据库并创建导入器
+func open() *models.GeoIPV10Importer {
+	connect()
+
+	imp, err := models.NewGeoIPV10Importer(app.DB, app.Flag.Import.Source, app.Flag.Import.Mode)
+	if err != nil {
+		exit(err)
+	}
+	imp.MinRatio = app.Flag.Import.MinRatio
+	return imp
+}
+
+// connect 连接数据库
+func connect() {
+	api.New().InitDb(app.Flag.App.DSN.PGSQL)
+	if app.DB == nil {
+		exit(fmt.Errorf("数据库连接失败"))
+	}
+}
+
+// finish 提交导入并打印统计
+func finish(imp *models.GeoIPV10Importer) {
+	if err := imp.Close(); err != nil {
+		exit(err)
+	}
+
+	stats := imp.Stats
+	fmt.Printf("Source:      %s\n", app.Flag.Import.Source)
+	fmt.Printf("Mode:        %s\n", app.Flag.Import.Mode)
+	fmt.Printf("Processed:   %d\n", stats.Processed)
+	fmt.Printf("Inserted:    %d\n", stats.Inserted)
+	fmt.Printf("Updated:     %d\n", stats.Updated)
+	fmt.Printf("Skipped:     %d\n", stats.Skipped)
+	fmt.Printf("Invalid:     %d\n", stats.Invalid)
+	if imp.Release != nil {
+		fmt.Printf("Deleted:     %d\n", stats.Deleted)
+		fmt.Printf("Version:     %d (previous %d, use `import rollback` to restore)\n", imp.Release.Version, imp.Release.Previous)
+	}
+	mlog.Info(mlog.H{"msg": "import completed", "source": app.Flag.Import.Source, "stats": stats})
+	mlog.Close()
+}
+
+func exit(err error) {
+	fmt.Println(err)
+	mlog.Error(mlog.H{"msg": "import failed", "err": err.Error()})
+	mlog.Close()
+	os.Exit(1)
+}
+
+func runRollback(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+	connect()
+
+	release, err := models.RollbackSource(app.DB, app.Flag.Import.Source)
+	if err != nil {
+		exit(err)
+	}
+	fmt.Printf("Source:      %s\n", release.Source)
+	fmt.Printf("Version:     %d (previous %d)\n", release.Version, release.Previous)
+	fmt.Printf("Rows:        %d\n", release.Rows)
+	mlog.Close()
+}
+
+func runReleases(cmd *cobra.Command, args []string) {
+	_ = map[string]any{"cmd": cmd, "args": args}
+	connect()
+	if err := models.EnsureReleaseTables(app.DB); err != nil {
+		exit(err)
+	}
+
+	source := ""
+	if cmd.Flags().Changed("source") {
+		source = app.Flag.Import.Source
+	}
+	releases, err := models.ListReleases(app.DB, source, 50)
+	if err != nil {
+		exit(err)
+	}
+	fmt.Printf("%-20s %-32s %-10s %-8s %-8s %s\n", "TIME", "SOURCE", "ACTION", "VERSION", "PREVIOUS", "ROWS")
+	for _, r := range releases {
+		fmt.Printf("%-20s %-32s %-10s %-8d %-8d %d\n", r.CreatedAt.Format("2006-01-02 15:04:05"), r.Source, r.Action, r.Version, r.Previous, r.Rows)
+	}
+	mlog.Close()
+}
@@ -1,6 +1,13 @@
 package server
 
 import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/lwmacct/250402-m-geoip/api"
+	"github.com/lwmacct/250402-m-geoip/api/v10/geoip"
+	"github.com/lwmacct/250402-m-geoip/api/whois"
 	"github.com/lwmacct/250402-m-geoip/app"
 
 	"github.com/lwmacct/250300-go-mod-mflag/pkg/mflag"
@@ -12,7 +19,7 @@ func Cmd() *mflag.Ts {
 	mc := mflag.New(app.Flag).UsePackageName("")
 	mc.AddCmd(func(cmd *cobra.Command, args []string) {
 		run(cmd, args)
-	}, "run", "", "app", "mlog", "server")
+	}, "run", "启动 WHOIS (RFC 3912) 查询服务, 与 HTTP 接口使用同一个查询服务", "app", "mlog", "server")
 	return mc
 }
 
@@ -20,5 +27,27 @@ func run(cmd *cobra.Command, args []string) {
 	_ = map[string]any{"cmd": cmd, "args": args}
 	mlog.Info(mlog.H{"msg": "app.Flag", "data": app.Flag})
 
+	api.New().InitDb(app.Flag.App.DSN.PGSQL)
+	if app.DB == nil {
+		exit(fmt.Errorf("数据库连接失败"))
+	}
+
+	cfg := app.Flag.Server
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		exit(err)
+	}
+	mlog.Info(mlog.H{"msg": "whois", "data": "Server starting on " + cfg.ListenAddr})
+	srv := whois.New(new(geoip.SrvDBQuery).Init(), cfg.Timeout, cfg.MaxItems)
+	if err := srv.Serve(listener); err != nil {
+		exit(err)
+	}
+	mlog.Close()
+}
+
+func exit(err error) {
+	fmt.Println(err)
+	mlog.Error(mlog.H{"msg": "server failed", "err": err.Error()})
 	mlog.Close()
+	os.Exit(1)
 }
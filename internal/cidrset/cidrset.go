@@ -0,0 +1,264 @@
+// Package cidrset 实现 CIDR 集合运算, 集合内部以排序且互不相邻的地址区间表示
+package cidrset
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Range 闭区间 [From, To], 两端地址族相同
+type Range struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// Set CIDR 集合, IPv4 与 IPv6 区间分别保存
+type Set struct {
+	v4 []Range
+	v6 []Range
+}
+
+// New 创建集合, 重叠或相邻的网络自动合并
+func New(prefixes ...netip.Prefix) *Set {
+	s := &Set{}
+	for _, p := range prefixes {
+		if !p.IsValid() {
+			continue
+		}
+		p = normalize(p)
+		r := Range{From: p.Addr(), To: lastAddr(p)}
+		if p.Addr().Is4() {
+			s.v4 = append(s.v4, r)
+		} else {
+			s.v6 = append(s.v6, r)
+		}
+	}
+	s.v4, s.v6 = merge(s.v4), merge(s.v6)
+	return s
+}
+
+// Aggregate 返回覆盖相同地址的最少 CIDR 列表, IPv4 在前
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	return New(prefixes...).Prefixes()
+}
+
+// Prefixes 返回集合的最少 CIDR 表示, 按地址排序, IPv4 在前
+func (s *Set) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range s.Ranges() {
+		prefixes = append(prefixes, RangePrefixes(r.From, r.To)...)
+	}
+	return prefixes
+}
+
+// Ranges 返回集合的区间表示, IPv4 在前
+func (s *Set) Ranges() []Range {
+	return append(append([]Range{}, s.v4...), s.v6...)
+}
+
+// IsEmpty 集合是否为空
+func (s *Set) IsEmpty() bool {
+	return len(s.v4) == 0 && len(s.v6) == 0
+}
+
+// Size 返回集合包含的地址数量, IPv4 与 IPv6 合计
+func (s *Set) Size() *big.Int {
+	total := new(big.Int)
+	for _, r := range s.Ranges() {
+		total.Add(total, r.Size())
+	}
+	return total
+}
+
+// Size 返回区间包含的地址数量
+func (r Range) Size() *big.Int {
+	from := new(big.Int).SetBytes(r.From.AsSlice())
+	to := new(big.Int).SetBytes(r.To.AsSlice())
+	return to.Sub(to, from).Add(to, big.NewInt(1))
+}
+
+// Union 返回两个集合的并集
+func (s *Set) Union(o *Set) *Set {
+	return &Set{
+		v4: merge(append(append([]Range{}, s.v4...), o.v4...)),
+		v6: merge(append(append([]Range{}, s.v6...), o.v6...)),
+	}
+}
+
+// Subtract 返回 s 中不属于 o 的部分
+func (s *Set) Subtract(o *Set) *Set {
+	return &Set{v4: subtract(s.v4, o.v4), v6: subtract(s.v6, o.v6)}
+}
+
+// Intersect 返回两个集合的交集
+func (s *Set) Intersect(o *Set) *Set {
+	return &Set{v4: intersect(s.v4, o.v4), v6: intersect(s.v6, o.v6)}
+}
+
+// ParseRange 解析 "起始IP-结束IP" 格式的区间, 两端可带空格, IPv4-mapped 地址按 IPv4 处理
+func ParseRange(s string) (Range, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Range{}, fmt.Errorf("无效的IP范围: %s", s)
+	}
+	a, err := netip.ParseAddr(strings.TrimSpace(from))
+	if err != nil {
+		return Range{}, fmt.Errorf("无效的起始IP: %s", from)
+	}
+	b, err := netip.ParseAddr(strings.TrimSpace(to))
+	if err != nil {
+		return Range{}, fmt.Errorf("无效的结束IP: %s", to)
+	}
+	r := Range{From: a.Unmap(), To: b.Unmap()}
+	if r.From.Is4() != r.To.Is4() {
+		return Range{}, fmt.Errorf("IP范围两端的地址族不同: %s", s)
+	}
+	if r.To.Less(r.From) {
+		return Range{}, fmt.Errorf("IP范围的起始IP大于结束IP: %s", s)
+	}
+	return r, nil
+}
+
+// PrefixRange 返回网络对应的地址区间
+func PrefixRange(p netip.Prefix) Range {
+	p = normalize(p)
+	return Range{From: p.Addr(), To: lastAddr(p)}
+}
+
+// Prefixes 将区间拆分为最少的 CIDR 列表
+func (r Range) Prefixes() []netip.Prefix {
+	return RangePrefixes(r.From, r.To)
+}
+
+// String 返回 "起始IP-结束IP" 格式
+func (r Range) String() string {
+	return r.From.String() + "-" + r.To.String()
+}
+
+// RangePrefixes 将地址区间拆分为最少的 CIDR 列表, 两端地址族不同或 from > to 时返回 nil
+func RangePrefixes(from, to netip.Addr) []netip.Prefix {
+	from, to = from.Unmap(), to.Unmap()
+	if !from.IsValid() || !to.IsValid() || from.Is4() != to.Is4() || to.Less(from) {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for {
+		// 从 from 开始取对齐且不超出 to 的最大网络
+		var p netip.Prefix
+		for bits := 0; bits <= from.BitLen(); bits++ {
+			p = netip.PrefixFrom(from, bits)
+			if p.Masked().Addr() == from && !to.Less(lastAddr(p)) {
+				break
+			}
+		}
+		prefixes = append(prefixes, p)
+
+		last := lastAddr(p)
+		if last == to {
+			return prefixes
+		}
+		from = last.Next()
+	}
+}
+
+// merge 排序并合并重叠或相邻的区间
+func merge(ranges []Range) []Range {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].From.Less(ranges[j].From)
+	})
+
+	merged := []Range{ranges[0]}
+	for _, r := range ranges[1:] {
+		cur := &merged[len(merged)-1]
+		next := cur.To.Next()
+		if !next.IsValid() || !next.Less(r.From) {
+			// 重叠或相邻
+			if cur.To.Less(r.To) {
+				cur.To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// subtract 从有序区间 a 中减去有序区间 b
+func subtract(a, b []Range) []Range {
+	var result []Range
+	j := 0
+	for _, r := range a {
+		// 跳过完全位于 r 之前的区间
+		for j < len(b) && b[j].To.Less(r.From) {
+			j++
+		}
+
+		cur, covered := r.From, false
+		for k := j; k < len(b) && !r.To.Less(b[k].From); k++ {
+			if cur.Less(b[k].From) {
+				result = append(result, Range{From: cur, To: b[k].From.Prev()})
+			}
+			next := b[k].To.Next()
+			if !next.IsValid() || r.To.Less(next) {
+				covered = true
+				break
+			}
+			if cur.Less(next) {
+				cur = next
+			}
+		}
+		if !covered {
+			result = append(result, Range{From: cur, To: r.To})
+		}
+	}
+	return result
+}
+
+// intersect 求有序区间 a 与 b 的交集
+func intersect(a, b []Range) []Range {
+	var result []Range
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		from, to := a[i].From, a[i].To
+		if from.Less(b[j].From) {
+			from = b[j].From
+		}
+		if b[j].To.Less(to) {
+			to = b[j].To
+		}
+		if !to.Less(from) {
+			result = append(result, Range{From: from, To: to})
+		}
+		if a[i].To.Less(b[j].To) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// normalize 清除主机位, IPv4 映射地址转换为 IPv4
+func normalize(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked()
+}
+
+// lastAddr 返回网络的最后一个地址
+func lastAddr(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
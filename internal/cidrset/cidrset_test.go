@@ -0,0 +1,133 @@
+package cidrset
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func set(prefixes ...string) *Set {
+	var ps []netip.Prefix
+	for _, p := range prefixes {
+		ps = append(ps, netip.MustParsePrefix(p))
+	}
+	return New(ps...)
+}
+
+func strs(prefixes []netip.Prefix) []string {
+	var out []string
+	for _, p := range prefixes {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{nil, nil},
+		// 相邻网络合并
+		{[]string{"10.0.0.0/25", "10.0.0.128/25"}, []string{"10.0.0.0/24"}},
+		{[]string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.0.0/23", "10.0.2.0/24"}},
+		// 相邻但无法合并为一个网络
+		{[]string{"10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.1.0/24", "10.0.2.0/24"}},
+		// 重叠与包含, 主机位清除, IPv4-mapped 按 IPv4 处理
+		{[]string{"10.0.0.5/8", "10.1.0.0/16", "::ffff:11.0.0.0/104"}, []string{"10.0.0.0/7"}},
+		// 全部地址, 末尾地址不会溢出
+		{[]string{"0.0.0.0/0", "255.255.255.255/32"}, []string{"0.0.0.0/0"}},
+		{[]string{"::/0", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff/128", "1.2.3.0/24"}, []string{"1.2.3.0/24", "::/0"}},
+		{[]string{"::/1", "8000::/1"}, []string{"::/0"}},
+	}
+	for _, tt := range tests {
+		if got := strs(set(tt.in...).Prefixes()); !slices.Equal(got, tt.want) {
+			t.Errorf("New(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(a, b *Set) *Set
+		a, b []string
+		want []string
+	}{
+		{"union adjacent", (*Set).Union, []string{"10.0.0.0/24"}, []string{"10.0.1.0/24"}, []string{"10.0.0.0/23"}},
+		{"union families", (*Set).Union, []string{"2001:db8::/32"}, []string{"10.0.0.0/8"}, []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{"subtract middle", (*Set).Subtract, []string{"10.0.0.0/24"}, []string{"10.0.0.128/26"}, []string{"10.0.0.0/25", "10.0.0.192/26"}},
+		{"subtract all v4", (*Set).Subtract, []string{"0.0.0.0/0"}, []string{"0.0.0.0/1"}, []string{"128.0.0.0/1"}},
+		{"subtract last address", (*Set).Subtract, []string{"0.0.0.0/0"}, []string{"0.0.0.0/1", "128.0.0.0/2", "192.0.0.0/2"}, nil},
+		{"subtract from all v6", (*Set).Subtract, []string{"::/0"}, []string{"8000::/1"}, []string{"::/1"}},
+		{"subtract covering", (*Set).Subtract, []string{"10.0.0.0/24", "10.0.2.0/24"}, []string{"10.0.0.0/8"}, nil},
+		{"subtract adjacent", (*Set).Subtract, []string{"10.0.0.0/24"}, []string{"10.0.1.0/24", "9.255.255.0/24"}, []string{"10.0.0.0/24"}},
+		{"subtract other family", (*Set).Subtract, []string{"10.0.0.0/8"}, []string{"::/0"}, []string{"10.0.0.0/8"}},
+		{"subtract several", (*Set).Subtract, []string{"10.0.0.0/22", "10.0.8.0/24"}, []string{"10.0.1.0/24", "10.0.2.0/25", "10.0.8.0/25"}, []string{"10.0.0.0/24", "10.0.2.128/25", "10.0.3.0/24", "10.0.8.128/25"}},
+		{"intersect", (*Set).Intersect, []string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16", "11.0.0.0/8", "::/0"}, []string{"10.1.0.0/16", "2001:db8::/32"}},
+		{"intersect adjacent", (*Set).Intersect, []string{"10.0.0.0/24"}, []string{"10.0.1.0/24"}, nil},
+		{"intersect all", (*Set).Intersect, []string{"0.0.0.0/0", "::/0"}, []string{"0.0.0.0/0", "::/0"}, []string{"0.0.0.0/0", "::/0"}},
+		{"intersect empty", (*Set).Intersect, nil, []string{"0.0.0.0/0"}, nil},
+	}
+	for _, tt := range tests {
+		got := tt.fn(set(tt.a...), set(tt.b...))
+		if prefixes := strs(got.Prefixes()); !slices.Equal(prefixes, tt.want) {
+			t.Errorf("%s: %v, %v = %v, want %v", tt.name, tt.a, tt.b, prefixes, tt.want)
+		}
+		if got.IsEmpty() != (len(tt.want) == 0) {
+			t.Errorf("%s: IsEmpty() = %v", tt.name, got.IsEmpty())
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{nil, "0"},
+		{[]string{"10.0.0.0/24", "10.0.0.128/25"}, "256"},
+		{[]string{"0.0.0.0/0"}, "4294967296"},
+		{[]string{"::/0", "0.0.0.0/0"}, "340282366920938463463374607436063178752"},
+	}
+	for _, tt := range tests {
+		if got := set(tt.in...).Size().String(); got != tt.want {
+			t.Errorf("Size(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"10.0.0.0-10.0.0.255", []string{"10.0.0.0/24"}, false},
+		{" 10.0.0.1 - 10.0.0.6 ", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}, false},
+		{"0.0.0.0-255.255.255.255", []string{"0.0.0.0/0"}, false},
+		{"::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}, false},
+		{"::ffff:1.2.3.4-1.2.3.4", []string{"1.2.3.4/32"}, false},
+		{"1.2.3.4-1.2.3.3", nil, true},
+		{"1.2.3.4-::1", nil, true},
+		{"1.2.3.4", nil, true},
+		{"x-1.2.3.4", nil, true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRange(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseRange(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got := strs(r.Prefixes()); err == nil && !slices.Equal(got, tt.want) {
+			t.Errorf("ParseRange(%q).Prefixes() = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if r := PrefixRange(netip.MustParsePrefix("10.1.2.3/16")); r.String() != "10.1.0.0-10.1.255.255" {
+		t.Errorf("PrefixRange = %s", r)
+	}
+	if got := RangePrefixes(netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("::1")); got != nil {
+		t.Errorf("RangePrefixes across families = %v, want nil", got)
+	}
+}
@@ -0,0 +1,204 @@
+// Package clientip 根据可信代理和转发请求头确定客户端IP
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// 支持的转发请求头
+const (
+	HeaderXForwardedFor  = "X-Forwarded-For"  // 逗号分隔的地址列表, 每经过一个代理在末尾追加
+	HeaderXRealIP        = "X-Real-IP"        // 单个地址
+	HeaderForwarded      = "Forwarded"        // RFC 7239, 逗号分隔的元素, 使用其中的 for= 参数
+	HeaderCFConnectingIP = "CF-Connecting-IP" // Cloudflare 设置的单个地址
+)
+
+// headerNames 规范化的请求头名称 -> 显示名称
+var headerNames = map[string]string{
+	http.CanonicalHeaderKey(HeaderXForwardedFor):  HeaderXForwardedFor,
+	http.CanonicalHeaderKey(HeaderXRealIP):        HeaderXRealIP,
+	http.CanonicalHeaderKey(HeaderForwarded):      HeaderForwarded,
+	http.CanonicalHeaderKey(HeaderCFConnectingIP): HeaderCFConnectingIP,
+}
+
+// SourceRemoteAddr 直连地址的来源名称
+const SourceRemoteAddr = "remote_addr"
+
+// Hop 转发链中的一个地址
+type Hop struct {
+	Addr    netip.Addr
+	Source  string // 来自的请求头, 直连地址为 remote_addr
+	Trusted bool   // 是否为可信代理
+}
+
+// Result 解析结果, Chain 从客户端到本服务依次排列, 最后一项为直连地址
+type Result struct {
+	Client netip.Addr
+	Source string // 确定客户端IP使用的请求头, 未使用转发头时为 remote_addr
+	Chain  []Hop
+}
+
+// Resolver 客户端IP解析器, 只有直连地址属于可信代理时才采用转发请求头
+type Resolver struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+// New 创建解析器, trusted 为可信代理的 CIDR 或 IP, headers 按优先级排列, 空字符串被忽略
+func New(trusted, headers []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, s := range trusted {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, err2 := netip.ParseAddr(s)
+			if err2 != nil {
+				return nil, fmt.Errorf("无效的可信代理: %s", s)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	for _, h := range headers {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		name, ok := headerNames[http.CanonicalHeaderKey(h)]
+		if !ok {
+			return nil, fmt.Errorf("不支持的请求头: %s, 可选 %s", h, strings.Join([]string{
+				HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded, HeaderCFConnectingIP,
+			}, ", "))
+		}
+		r.headers = append(r.headers, name)
+	}
+	return r, nil
+}
+
+// Trusted 返回可信代理列表
+func (r *Resolver) Trusted() []netip.Prefix {
+	return r.trusted
+}
+
+// IsTrusted 判断地址是否属于可信代理
+func (r *Resolver) IsTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 解析请求的客户端IP
+// 依次尝试各请求头, 从右向左跳过可信代理, 第一个不可信的地址即为客户端, 全部可信时取最左边的地址;
+// 在找到客户端之前遇到无法解析的地址时放弃该请求头, 客户端左侧无法解析的地址不出现在转发链中
+func (r *Resolver) Resolve(req *http.Request) Result {
+	remote := ParseAddr(req.RemoteAddr)
+	direct := Hop{Addr: remote, Source: SourceRemoteAddr, Trusted: remote.IsValid() && r.IsTrusted(remote)}
+	result := Result{Client: remote, Source: SourceRemoteAddr, Chain: []Hop{direct}}
+	if !direct.Trusted {
+		return result
+	}
+
+	for _, name := range r.headers {
+		values := headerAddrs(name, req.Header)
+		client := -1
+		for i := len(values) - 1; i >= 0; i-- {
+			addr := ParseAddr(values[i])
+			if !addr.IsValid() {
+				break
+			}
+			client = i
+			if !r.IsTrusted(addr) {
+				break
+			}
+		}
+		if client < 0 || (client > 0 && r.IsTrusted(ParseAddr(values[client]))) {
+			// 没有可用的地址, 或者全部可信但左侧存在无法解析的地址
+			continue
+		}
+
+		chain := []Hop{}
+		for _, v := range values {
+			if addr := ParseAddr(v); addr.IsValid() {
+				chain = append(chain, Hop{Addr: addr, Source: name, Trusted: r.IsTrusted(addr)})
+			}
+		}
+		return Result{Client: ParseAddr(values[client]), Source: name, Chain: append(chain, direct)}
+	}
+	return result
+}
+
+// headerAddrs 读取请求头中的地址列表, 同名请求头按出现顺序合并
+func headerAddrs(name string, header http.Header) []string {
+	var values []string
+	for _, line := range header.Values(name) {
+		switch name {
+		case HeaderForwarded:
+			values = append(values, forwardedFor(line)...)
+		case HeaderXForwardedFor:
+			for _, v := range strings.Split(line, ",") {
+				values = append(values, strings.TrimSpace(v))
+			}
+		default:
+			values = append(values, strings.TrimSpace(line))
+		}
+	}
+	return values
+}
+
+// forwardedFor 提取 Forwarded 请求头中每个元素的 for= 参数, 缺少 for= 的元素返回空字符串
+func forwardedFor(line string) []string {
+	var values []string
+	for _, element := range splitQuoted(line, ',') {
+		value := ""
+		for _, pair := range splitQuoted(element, ';') {
+			k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "for") {
+				value = strings.Trim(strings.TrimSpace(v), `"`)
+			}
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// splitQuoted 按分隔符拆分, 忽略双引号内的分隔符
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// ParseAddr 解析地址, 支持 IP、IP:端口 与 [IPv6]:端口, 无法解析时返回无效地址
+func ParseAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap().WithZone("")
+}
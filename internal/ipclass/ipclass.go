@@ -0,0 +1,127 @@
+// Package ipclass 根据 IANA 特殊用途地址注册表 (RFC 6890 及后续 RFC) 对地址分类
+package ipclass
+
+import (
+	"net/netip"
+
+	"github.com/lwmacct/250402-m-geoip/internal/iptrie"
+)
+
+// 地址分类
+const (
+	Private       = "private"       // 私有地址, 含 IPv6 唯一本地地址
+	Loopback      = "loopback"      // 回环地址
+	CGNAT         = "cgnat"         // 运营商级 NAT 共享地址
+	LinkLocal     = "link_local"    // 链路本地地址
+	Multicast     = "multicast"     // 组播地址
+	Documentation = "documentation" // 文档示例地址
+	Reserved      = "reserved"      // 其他保留或未分配地址
+	Global        = "global"        // 公网地址
+)
+
+// Classes 全部分类
+var Classes = []string{Private, Loopback, CGNAT, LinkLocal, Multicast, Documentation, Reserved, Global}
+
+// Info 分类结果, Prefix 为命中的注册表条目, 普通公网地址没有条目
+type Info struct {
+	Class  string       `json:"class"`
+	Name   string       `json:"name,omitempty"`
+	RFC    string       `json:"rfc,omitempty"`
+	Prefix netip.Prefix `json:"prefix,omitzero"`
+}
+
+// IsGlobal 是否为公网地址
+func (i Info) IsGlobal() bool {
+	return i.Class == Global
+}
+
+// registry 注册表, 按最长前缀匹配, 更具体的条目覆盖所在的大段
+// IPv6 中 2000::/3 之外的地址尚未分配, 整体视为保留地址; IPv4-mapped 地址在分类前转换为 IPv4, 不单独列出
+var registry = func() *iptrie.Tree[Info] {
+	tree := iptrie.New[Info]()
+	for _, e := range []struct {
+		prefix, class, name, rfc string
+	}{
+		{"0.0.0.0/8", Reserved, "This network", "RFC 791"},
+		{"10.0.0.0/8", Private, "Private-Use", "RFC 1918"},
+		{"100.64.0.0/10", CGNAT, "Shared Address Space", "RFC 6598"},
+		{"127.0.0.0/8", Loopback, "Loopback", "RFC 1122"},
+		{"169.254.0.0/16", LinkLocal, "Link Local", "RFC 3927"},
+		{"172.16.0.0/12", Private, "Private-Use", "RFC 1918"},
+		{"192.0.0.0/24", Reserved, "IETF Protocol Assignments", "RFC 6890"},
+		{"192.0.0.0/29", Reserved, "IPv4 Service Continuity Prefix", "RFC 7335"},
+		{"192.0.0.9/32", Global, "Port Control Protocol Anycast", "RFC 7723"},
+		{"192.0.0.10/32", Global, "Traversal Using Relays around NAT Anycast", "RFC 8155"},
+		{"192.0.2.0/24", Documentation, "Documentation (TEST-NET-1)", "RFC 5737"},
+		{"192.88.99.0/24", Reserved, "Deprecated 6to4 Relay Anycast", "RFC 7526"},
+		{"192.168.0.0/16", Private, "Private-Use", "RFC 1918"},
+		{"198.18.0.0/15", Reserved, "Benchmarking", "RFC 2544"},
+		{"198.51.100.0/24", Documentation, "Documentation (TEST-NET-2)", "RFC 5737"},
+		{"203.0.113.0/24", Documentation, "Documentation (TEST-NET-3)", "RFC 5737"},
+		{"224.0.0.0/4", Multicast, "Multicast", "RFC 5771"},
+		{"233.252.0.0/24", Documentation, "Documentation (MCAST-TEST-NET)", "RFC 6676"},
+		{"240.0.0.0/4", Reserved, "Reserved", "RFC 1112"},
+		{"255.255.255.255/32", Reserved, "Limited Broadcast", "RFC 919"},
+
+		{"::/0", Reserved, "Unallocated", "RFC 4291"},
+		{"::/128", Reserved, "Unspecified Address", "RFC 4291"},
+		{"::1/128", Loopback, "Loopback Address", "RFC 4291"},
+		{"64:ff9b::/96", Global, "IPv4-IPv6 Translation", "RFC 6052"},
+		{"64:ff9b:1::/48", Reserved, "IPv4-IPv6 Local-Use Translation", "RFC 8215"},
+		{"100::/64", Reserved, "Discard-Only Address Block", "RFC 6666"},
+		{"2000::/3", Global, "", ""},
+		{"2001::/23", Reserved, "IETF Protocol Assignments", "RFC 2928"},
+		{"2001::/32", Global, "Teredo", "RFC 4380"},
+		{"2001:1::1/128", Global, "Port Control Protocol Anycast", "RFC 7723"},
+		{"2001:1::2/128", Global, "Traversal Using Relays around NAT Anycast", "RFC 8155"},
+		{"2001:2::/48", Reserved, "Benchmarking", "RFC 5180"},
+		{"2001:3::/32", Global, "AMT", "RFC 7450"},
+		{"2001:4:112::/48", Global, "AS112-v6", "RFC 7535"},
+		{"2001:20::/28", Global, "ORCHIDv2", "RFC 7343"},
+		{"2001:30::/28", Global, "Drone Remote ID Protocol Entity Tags (DETs) Prefix", "RFC 9374"},
+		{"2001:db8::/32", Documentation, "Documentation", "RFC 3849"},
+		{"2002::/16", Global, "6to4", "RFC 3056"},
+		{"3fff::/20", Documentation, "Documentation", "RFC 9637"},
+		{"5f00::/16", Reserved, "Segment Routing (SRv6) SIDs", "RFC 9602"},
+		{"fc00::/7", Private, "Unique-Local", "RFC 4193"},
+		{"fe80::/10", LinkLocal, "Link-Local Unicast", "RFC 4291"},
+		{"ff00::/8", Multicast, "Multicast", "RFC 4291"},
+	} {
+		prefix := netip.MustParsePrefix(e.prefix)
+		tree.Insert(prefix, Info{Class: e.class, Name: e.name, RFC: e.rfc, Prefix: prefix})
+	}
+	return tree
+}()
+
+// Classify 对地址分类, IPv4-mapped 地址按其中的 IPv4 地址分类
+func Classify(addr netip.Addr) Info {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return Info{Class: Reserved}
+	}
+	if _, info, ok := registry.Lookup(addr); ok {
+		return info
+	}
+	return Info{Class: Global}
+}
+
+// ClassifyPrefix 对网络分类, 使用完整包含该网络的最具体条目, 跨越多个分类的网络按所在的大段分类
+func ClassifyPrefix(p netip.Prefix) Info {
+	if !p.IsValid() {
+		return Info{Class: Reserved}
+	}
+	if entries := registry.Supernets(p); len(entries) > 0 {
+		return entries[0].Value
+	}
+	return Info{Class: Global}
+}
+
+// Valid 判断分类名称是否有效
+func Valid(class string) bool {
+	for _, c := range Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package ipclass
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		addr  string
+		class string
+		entry string // 命中的注册表条目, 为空表示没有条目
+	}{
+		{"10.1.2.3", Private, "10.0.0.0/8"},
+		{"172.31.255.255", Private, "172.16.0.0/12"},
+		{"172.32.0.1", Global, ""},
+		{"192.168.1.1", Private, "192.168.0.0/16"},
+		{"100.64.0.1", CGNAT, "100.64.0.0/10"},
+		{"100.128.0.1", Global, ""},
+		{"127.0.0.1", Loopback, "127.0.0.0/8"},
+		{"169.254.1.1", LinkLocal, "169.254.0.0/16"},
+		{"0.1.2.3", Reserved, "0.0.0.0/8"},
+		{"192.0.2.1", Documentation, "192.0.2.0/24"},
+		{"198.51.100.1", Documentation, "198.51.100.0/24"},
+		{"203.0.113.1", Documentation, "203.0.113.0/24"},
+		{"224.0.0.1", Multicast, "224.0.0.0/4"},
+		{"233.252.0.1", Documentation, "233.252.0.0/24"},
+		{"240.0.0.1", Reserved, "240.0.0.0/4"},
+		{"255.255.255.255", Reserved, "255.255.255.255/32"},
+		// 更具体的条目覆盖所在的大段
+		{"192.0.0.1", Reserved, "192.0.0.0/29"},
+		{"192.0.0.9", Global, "192.0.0.9/32"},
+		{"192.0.0.100", Reserved, "192.0.0.0/24"},
+		{"8.8.8.8", Global, ""},
+		// IPv4-mapped 按 IPv4 分类
+		{"::ffff:10.0.0.1", Private, "10.0.0.0/8"},
+		{"::ffff:8.8.8.8", Global, ""},
+
+		{"::", Reserved, "::/128"},
+		{"::1", Loopback, "::1/128"},
+		{"::2", Reserved, "::/0"},
+		{"fe80::1", LinkLocal, "fe80::/10"},
+		{"fd00::1", Private, "fc00::/7"},
+		{"ff02::1", Multicast, "ff00::/8"},
+		{"2001:db8::1", Documentation, "2001:db8::/32"},
+		{"3fff::1", Documentation, "3fff::/20"},
+		{"2400:3200::1", Global, "2000::/3"},
+		{"2001::1", Global, "2001::/32"},
+		{"2001:2::1", Reserved, "2001:2::/48"},
+		{"2001:1::1", Global, "2001:1::1/128"},
+		{"2001:1::3", Reserved, "2001::/23"},
+		{"2002:808:808::1", Global, "2002::/16"},
+		{"64:ff9b::808:808", Global, "64:ff9b::/96"},
+		{"64:ff9b:1::1", Reserved, "64:ff9b:1::/48"},
+		{"4000::1", Reserved, "::/0"},
+	}
+	for _, tt := range tests {
+		info := Classify(netip.MustParseAddr(tt.addr))
+		entry := ""
+		if info.Prefix.IsValid() {
+			entry = info.Prefix.String()
+		}
+		if info.Class != tt.class || entry != tt.entry {
+			t.Errorf("Classify(%s) = %s %s, want %s %s", tt.addr, info.Class, entry, tt.class, tt.entry)
+		}
+		if info.IsGlobal() != (tt.class == Global) {
+			t.Errorf("Classify(%s).IsGlobal() = %v", tt.addr, info.IsGlobal())
+		}
+	}
+
+	if info := Classify(netip.Addr{}); info.Class != Reserved {
+		t.Errorf("Classify(invalid) = %s, want %s", info.Class, Reserved)
+	}
+}
+
+func TestClassifyPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		class  string
+	}{
+		{"10.1.0.0/16", Private},
+		{"10.0.0.0/8", Private},
+		{"192.168.1.0/24", Private},
+		// 跨越多个分类的网络按所在的大段分类
+		{"192.0.0.0/28", Reserved},
+		{"192.0.0.8/30", Reserved},
+		{"10.0.0.0/7", Global},
+		{"0.0.0.0/0", Global},
+		{"8.8.8.0/24", Global},
+		{"fe80::/64", LinkLocal},
+		{"2001:db8:1::/48", Documentation},
+		{"2400::/12", Global},
+		{"::/0", Reserved},
+		{"::ffff:10.0.0.0/104", Private},
+	}
+	for _, tt := range tests {
+		if info := ClassifyPrefix(netip.MustParsePrefix(tt.prefix)); info.Class != tt.class {
+			t.Errorf("ClassifyPrefix(%s) = %s, want %s", tt.prefix, info.Class, tt.class)
+		}
+	}
+
+	if info := ClassifyPrefix(netip.Prefix{}); info.Class != Reserved {
+		t.Errorf("ClassifyPrefix(invalid) = %s, want %s", info.Class, Reserved)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, class := range Classes {
+		if !Valid(class) {
+			t.Errorf("Valid(%s) = false", class)
+		}
+	}
+	for _, class := range []string{"", "Private", "public", "bogon"} {
+		if Valid(class) {
+			t.Errorf("Valid(%q) = true", class)
+		}
+	}
+}
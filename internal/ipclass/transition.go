@@ -0,0 +1,46 @@
+package ipclass
+
+import (
+	"net/netip"
+)
+
+// IPv6 过渡机制
+const (
+	MechanismMapped = "ipv4_mapped" // ::ffff:a.b.c.d
+	Mechanism6to4   = "6to4"        // 2002:aabb:ccdd::/48
+	MechanismTeredo = "teredo"      // 2001::/32, 客户端地址按位取反保存在最后 32 位
+	MechanismNAT64  = "nat64"       // 64:ff9b::/96 知名前缀
+)
+
+var (
+	prefix6to4   = netip.MustParsePrefix("2002::/16")
+	prefixTeredo = netip.MustParsePrefix("2001::/32")
+	prefixNAT64  = netip.MustParsePrefix("64:ff9b::/96")
+)
+
+// Transition IPv6 过渡地址中内嵌的 IPv4 地址
+type Transition struct {
+	Mechanism string
+	IPv4      netip.Addr
+	Server    netip.Addr // Teredo 服务器地址, 其他机制为空
+}
+
+// Unwrap 提取 IPv6 过渡地址中内嵌的 IPv4 地址, 不是过渡地址时返回 false
+func Unwrap(addr netip.Addr) (Transition, bool) {
+	if !addr.Is6() {
+		return Transition{}, false
+	}
+	b := addr.As16()
+	switch {
+	case addr.Is4In6():
+		return Transition{Mechanism: MechanismMapped, IPv4: addr.Unmap()}, true
+	case prefix6to4.Contains(addr):
+		return Transition{Mechanism: Mechanism6to4, IPv4: netip.AddrFrom4([4]byte(b[2:6]))}, true
+	case prefixTeredo.Contains(addr):
+		client := [4]byte{^b[12], ^b[13], ^b[14], ^b[15]}
+		return Transition{Mechanism: MechanismTeredo, IPv4: netip.AddrFrom4(client), Server: netip.AddrFrom4([4]byte(b[4:8]))}, true
+	case prefixNAT64.Contains(addr):
+		return Transition{Mechanism: MechanismNAT64, IPv4: netip.AddrFrom4([4]byte(b[12:16]))}, true
+	}
+	return Transition{}, false
+}
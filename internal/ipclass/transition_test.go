@@ -0,0 +1,46 @@
+package ipclass
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		addr      string
+		mechanism string // 为空表示不是过渡地址
+		ipv4      string
+		server    string
+	}{
+		{"::ffff:1.2.3.4", MechanismMapped, "1.2.3.4", ""},
+		{"2002:7af6:4bb5::1", Mechanism6to4, "122.246.75.181", ""},
+		{"2002:c000:22a:1:2:3:4:5", Mechanism6to4, "192.0.2.42", ""},
+		// RFC 4380 的示例, 客户端地址按位取反
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", MechanismTeredo, "192.0.2.45", "65.54.227.120"},
+		{"64:ff9b::c000:221", MechanismNAT64, "192.0.2.33", ""},
+
+		{"1.2.3.4", "", "", ""},
+		{"2001:db8::1", "", "", ""},
+		{"2001:1::1", "", "", ""},
+		{"2003::1", "", "", ""},
+		{"64:ff9b:1::c000:221", "", "", ""},
+		{"::1.2.3.4", "", "", ""},
+	}
+	for _, tt := range tests {
+		tr, ok := Unwrap(netip.MustParseAddr(tt.addr))
+		if ok != (tt.mechanism != "") {
+			t.Errorf("Unwrap(%s) ok = %v", tt.addr, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		server := ""
+		if tr.Server.IsValid() {
+			server = tr.Server.String()
+		}
+		if tr.Mechanism != tt.mechanism || tr.IPv4.String() != tt.ipv4 || server != tt.server {
+			t.Errorf("Unwrap(%s) = %s %s %s, want %s %s %s", tt.addr, tr.Mechanism, tr.IPv4, server, tt.mechanism, tt.ipv4, tt.server)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package iptrie 实现基于路径压缩的二叉前缀树 (Patricia trie), 支持 IPv4 与 IPv6 的最长前缀匹配
+package iptrie
+
+import (
+	"net/netip"
+)
+
+// Entry 前缀与对应的值
+type Entry[T any] struct {
+	Prefix netip.Prefix
+	Value  T
+}
+
+// Tree 压缩前缀树, IPv4 与 IPv6 分别使用独立的根节点
+//
+// Tree 不是并发安全的, 构建完成后只读使用可在多个 goroutine 间共享
+type Tree[T any] struct {
+	v4   *node[T]
+	v6   *node[T]
+	size int
+}
+
+type node[T any] struct {
+	prefix netip.Prefix
+	child  [2]*node[T]
+	set    bool // 是否为真实插入的前缀, 否则为分叉用的中间节点
+	value  T
+}
+
+// New 创建前缀树
+func New[T any]() *Tree[T] {
+	return &Tree[T]{}
+}
+
+// Len 返回已插入的前缀数量
+func (t *Tree[T]) Len() int {
+	return t.size
+}
+
+// Insert 插入前缀, 已存在时覆盖旧值
+func (t *Tree[T]) Insert(p netip.Prefix, v T) {
+	t.Update(p, func(T, bool) T { return v })
+}
+
+// Update 插入或更新前缀, fn 接收旧值及其是否存在, 返回新值
+func (t *Tree[T]) Update(p netip.Prefix, fn func(old T, ok bool) T) {
+	p = normalize(p)
+	if !p.IsValid() {
+		return
+	}
+
+	pp := t.root(p.Addr())
+	for {
+		n := *pp
+		if n == nil {
+			*pp = &node[T]{prefix: p, set: true, value: fn(zero[T](), false)}
+			t.size++
+			return
+		}
+
+		common := commonBits(n.prefix, p)
+		switch {
+		case common == n.prefix.Bits() && common == p.Bits():
+			// 同一前缀
+			if !n.set {
+				t.size++
+			}
+			n.value = fn(n.value, n.set)
+			n.set = true
+			return
+
+		case common == n.prefix.Bits():
+			// n 是 p 的祖先, 继续向下
+			pp = &n.child[bitAt(p.Addr(), common)]
+
+		case common == p.Bits():
+			// p 是 n 的祖先, 插入到 n 之上
+			nn := &node[T]{prefix: p, set: true, value: fn(zero[T](), false)}
+			nn.child[bitAt(n.prefix.Addr(), common)] = n
+			*pp = nn
+			t.size++
+			return
+
+		default:
+			// 在分叉处创建中间节点
+			glue := &node[T]{prefix: netip.PrefixFrom(p.Addr(), common).Masked()}
+			glue.child[bitAt(n.prefix.Addr(), common)] = n
+			glue.child[bitAt(p.Addr(), common)] = &node[T]{prefix: p, set: true, value: fn(zero[T](), false)}
+			*pp = glue
+			t.size++
+			return
+		}
+	}
+}
+
+// Get 精确查找前缀
+func (t *Tree[T]) Get(p netip.Prefix) (T, bool) {
+	p = normalize(p)
+	if !p.IsValid() {
+		return zero[T](), false
+	}
+
+	n := *t.root(p.Addr())
+	for n != nil && n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr()) {
+		if n.prefix.Bits() == p.Bits() {
+			if n.set {
+				return n.value, true
+			}
+			break
+		}
+		n = n.child[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+	return zero[T](), false
+}
+
+// Lookup 最长前缀匹配, 返回包含该地址的最具体前缀
+func (t *Tree[T]) Lookup(addr netip.Addr) (netip.Prefix, T, bool) {
+	matches := t.Matches(addr)
+	if len(matches) == 0 {
+		return netip.Prefix{}, zero[T](), false
+	}
+	best := matches[0]
+	return best.Prefix, best.Value, true
+}
+
+// Matches 返回所有包含该地址的前缀, 按前缀长度从长到短排列
+func (t *Tree[T]) Matches(addr netip.Addr) []Entry[T] {
+	return t.covering(netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+}
+
+// Supernets 返回所有包含 p 的前缀 (含 p 本身), 按前缀长度从长到短排列
+func (t *Tree[T]) Supernets(p netip.Prefix) []Entry[T] {
+	return t.covering(normalize(p))
+}
+
+// Subnets 返回 p 包含的所有前缀 (含 p 本身), 按地址顺序排列
+func (t *Tree[T]) Subnets(p netip.Prefix) []Entry[T] {
+	var entries []Entry[T]
+	t.WalkSubnets(p, func(prefix netip.Prefix, v T) bool {
+		entries = append(entries, Entry[T]{Prefix: prefix, Value: v})
+		return true
+	})
+	return entries
+}
+
+// WalkSubnets 按地址顺序遍历 p 包含的所有前缀 (含 p 本身, 最先访问), fn 返回 false 时停止
+func (t *Tree[T]) WalkSubnets(p netip.Prefix, fn func(p netip.Prefix, v T) bool) {
+	p = normalize(p)
+	if !p.IsValid() {
+		return
+	}
+
+	n := *t.root(p.Addr())
+	for n != nil {
+		if n.prefix.Bits() >= p.Bits() {
+			// 第一个不短于 p 的节点, 其子树即为 p 包含的全部前缀
+			if p.Contains(n.prefix.Addr()) {
+				walk(n, fn)
+			}
+			return
+		}
+		if !n.prefix.Contains(p.Addr()) {
+			return
+		}
+		n = n.child[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+}
+
+// covering 沿路径收集覆盖 p 的所有前缀
+func (t *Tree[T]) covering(p netip.Prefix) []Entry[T] {
+	if !p.IsValid() {
+		return nil
+	}
+
+	var path []Entry[T]
+	n := *t.root(p.Addr())
+	for n != nil && n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr()) {
+		if n.set {
+			path = append(path, Entry[T]{Prefix: n.prefix, Value: n.value})
+		}
+		if n.prefix.Bits() == p.Bits() {
+			break
+		}
+		n = n.child[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+
+	// 反转为从长到短
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Walk 按地址顺序遍历所有前缀, fn 返回 false 时停止
+func (t *Tree[T]) Walk(fn func(p netip.Prefix, v T) bool) {
+	if walk(t.v4, fn) {
+		walk(t.v6, fn)
+	}
+}
+
+func walk[T any](n *node[T], fn func(netip.Prefix, T) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.set && !fn(n.prefix, n.value) {
+		return false
+	}
+	return walk(n.child[0], fn) && walk(n.child[1], fn)
+}
+
+func (t *Tree[T]) root(addr netip.Addr) **node[T] {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+// normalize 将 IPv4-mapped 前缀转换为 IPv4 前缀并清除主机位
+func normalize(p netip.Prefix) netip.Prefix {
+	if !p.IsValid() {
+		return p
+	}
+	if addr := p.Addr(); addr.Is4In6() {
+		bits := p.Bits() - 96
+		if bits < 0 {
+			return netip.PrefixFrom(addr, p.Bits()).Masked()
+		}
+		p = netip.PrefixFrom(addr.Unmap(), bits)
+	}
+	return p.Masked()
+}
+
+// commonBits 计算两个前缀的公共前缀长度
+func commonBits(a, b netip.Prefix) int {
+	limit := min(a.Bits(), b.Bits())
+	ab, bb := a.Addr().As16(), b.Addr().As16()
+	offset := 0
+	if a.Addr().Is4() {
+		offset = 12
+	}
+
+	n := 0
+	for i := offset; i < 16 && n < limit; i++ {
+		x := ab[i] ^ bb[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return min(n, limit)
+}
+
+// bitAt 返回地址第 i 位 (从最高位开始计数)
+func bitAt(addr netip.Addr, i int) int {
+	b := addr.As16()
+	if addr.Is4() {
+		i += 96
+	}
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+func zero[T any]() T {
+	var v T
+	return v
+}
@@ -0,0 +1,145 @@
+package iptrie
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func build(prefixes ...string) *Tree[string] {
+	tree := New[string]()
+	for _, p := range prefixes {
+		tree.Insert(netip.MustParsePrefix(p), p)
+	}
+	return tree
+}
+
+func prefixes(entries []Entry[string]) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Prefix.String())
+	}
+	return out
+}
+
+func TestLookup(t *testing.T) {
+	tree := build("0.0.0.0/0", "1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.2.3.128/25", "10.0.0.0/8", "::/0", "2001:db8::/32", "2001:db8:1::/48")
+
+	tests := []struct {
+		addr string
+		want string
+		ok   bool
+	}{
+		{"1.2.3.4", "1.2.3.0/24", true},
+		{"1.2.3.200", "1.2.3.128/25", true},
+		{"1.2.4.1", "1.2.0.0/16", true},
+		{"1.3.0.1", "1.0.0.0/8", true},
+		{"9.9.9.9", "0.0.0.0/0", true},
+		{"::ffff:1.2.3.4", "1.2.3.0/24", true},
+		{"2001:db8:1::1", "2001:db8:1::/48", true},
+		{"2001:db8:2::1", "2001:db8::/32", true},
+		{"2001:db9::1", "::/0", true},
+	}
+	for _, tt := range tests {
+		p, v, ok := tree.Lookup(netip.MustParseAddr(tt.addr))
+		if ok != tt.ok || p.String() != tt.want || v != tt.want {
+			t.Errorf("Lookup(%s) = %s, %q, %v, want %s", tt.addr, p, v, ok, tt.want)
+		}
+	}
+
+	if _, _, ok := build("1.0.0.0/8").Lookup(netip.MustParseAddr("2.0.0.1")); ok {
+		t.Errorf("Lookup outside all prefixes should not match")
+	}
+	if _, _, ok := build("1.0.0.0/8").Lookup(netip.MustParseAddr("::1")); ok {
+		t.Errorf("IPv6 lookup should not match IPv4 prefixes")
+	}
+}
+
+func TestInsertGet(t *testing.T) {
+	tree := build("1.2.3.0/24", "1.2.0.0/16", "1.2.3.0/24")
+	if tree.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", tree.Len())
+	}
+
+	// 主机位被清除, IPv4-mapped 前缀视为 IPv4
+	for _, p := range []string{"1.2.3.9/24", "::ffff:1.2.3.0/120"} {
+		if v, ok := tree.Get(netip.MustParsePrefix(p)); !ok || v != "1.2.3.0/24" {
+			t.Errorf("Get(%s) = %q, %v", p, v, ok)
+		}
+	}
+	// 分叉产生的中间节点不是真实前缀
+	tree = build("1.2.3.0/24", "1.2.4.0/24")
+	if _, ok := tree.Get(netip.MustParsePrefix("1.2.0.0/21")); ok {
+		t.Errorf("Get on glue node should not match")
+	}
+	if tree.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", tree.Len())
+	}
+
+	tree.Update(netip.MustParsePrefix("1.2.3.0/24"), func(old string, ok bool) string {
+		if !ok {
+			t.Errorf("Update should see existing value")
+		}
+		return old + "!"
+	})
+	if v, _ := tree.Get(netip.MustParsePrefix("1.2.3.0/24")); v != "1.2.3.0/24!" {
+		t.Errorf("Update result = %q", v)
+	}
+}
+
+func TestSupernetsSubnets(t *testing.T) {
+	tree := build("0.0.0.0/0", "1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.2.4.0/24", "1.3.0.0/16", "2.0.0.0/8", "::/0", "2001:db8::/32")
+
+	tests := []struct {
+		name string
+		fn   func(netip.Prefix) []Entry[string]
+		in   string
+		want []string
+	}{
+		{"supernets", tree.Supernets, "1.2.3.0/24", []string{"1.2.3.0/24", "1.2.0.0/16", "1.0.0.0/8", "0.0.0.0/0"}},
+		{"supernets glue", tree.Supernets, "1.2.0.0/20", []string{"1.2.0.0/16", "1.0.0.0/8", "0.0.0.0/0"}},
+		{"supernets v6", tree.Supernets, "2001:db8:1::/48", []string{"2001:db8::/32", "::/0"}},
+		{"subnets", tree.Subnets, "1.0.0.0/8", []string{"1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.2.4.0/24", "1.3.0.0/16"}},
+		{"subnets glue", tree.Subnets, "1.2.0.0/20", []string{"1.2.3.0/24", "1.2.4.0/24"}},
+		{"subnets all v4", tree.Subnets, "0.0.0.0/0", []string{"0.0.0.0/0", "1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.2.4.0/24", "1.3.0.0/16", "2.0.0.0/8"}},
+		{"subnets v6 excludes v4", tree.Subnets, "::/0", []string{"::/0", "2001:db8::/32"}},
+		{"subnets none", tree.Subnets, "3.0.0.0/8", nil},
+	}
+	for _, tt := range tests {
+		if got := prefixes(tt.fn(netip.MustParsePrefix(tt.in))); !slices.Equal(got, tt.want) {
+			t.Errorf("%s(%s) = %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tree := build("2001:db8::/32", "10.0.0.0/8", "1.2.3.0/24", "1.0.0.0/8", "::/0")
+	var got []string
+	tree.Walk(func(p netip.Prefix, _ string) bool {
+		got = append(got, p.String())
+		return true
+	})
+	want := []string{"1.0.0.0/8", "1.2.3.0/24", "10.0.0.0/8", "::/0", "2001:db8::/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Walk = %v, want %v", got, want)
+	}
+
+	var n int
+	tree.Walk(func(netip.Prefix, string) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Errorf("Walk should stop when fn returns false, visited %d", n)
+	}
+
+	// WalkSubnets 先访问 p 本身, 可提前停止
+	var subnets []string
+	build("1.0.0.0/8", "1.2.0.0/16", "1.2.3.0/24", "1.3.0.0/16").WalkSubnets(netip.MustParsePrefix("1.0.0.0/8"), func(p netip.Prefix, _ string) bool {
+		subnets = append(subnets, p.String())
+		return len(subnets) < 2
+	})
+	if want := []string{"1.0.0.0/8", "1.2.0.0/16"}; !slices.Equal(subnets, want) {
+		t.Errorf("WalkSubnets = %v, want %v", subnets, want)
+	}
+}
@@ -0,0 +1,423 @@
+// Package mmdbwriter 生成 MaxMind DB 格式 (.mmdb) 文件, 格式说明见 https://maxmind.github.io/MaxMind-DB/
+//
+// 生成的文件使用 IPv6 搜索树, IPv4 网络保存在 ::/96 下, 并将 ::ffff:0:0/96 与 2002::/16 指向 IPv4 子树
+package mmdbwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// 数据段类型, 见规范 "Output Data Section"
+const (
+	typeString  = 2
+	typeDouble  = 3
+	typeBytes   = 4
+	typeUint16  = 5
+	typeUint32  = 6
+	typeMap     = 7
+	typeInt32   = 8
+	typeUint64  = 9
+	typeArray   = 11
+	typeBoolean = 14
+	typeFloat   = 15
+)
+
+// 数据段与元数据之间的分隔标记
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Metadata 数据库元数据
+type Metadata struct {
+	DatabaseType string
+	Description  map[string]string
+	Languages    []string
+	BuildEpoch   time.Time // 为零时使用写入时间
+}
+
+// Writer 在内存中构建搜索树和数据段, 完成后通过 WriteTo 输出
+//
+// 相同的记录在数据段中只保存一份
+type Writer struct {
+	meta     Metadata
+	root     *node
+	data     bytes.Buffer
+	cache    map[string]int
+	networks int
+
+	v4     *node // ::/96 对应的节点, IPv4 数据的根
+	v4data bool  // 是否插入过 IPv4 网络
+}
+
+type node struct {
+	child [2]record
+}
+
+// record 搜索树中的一条记录: 指向子节点、指向数据或为空
+type record struct {
+	node   *node
+	offset int // 数据段偏移, set 为 true 时有效
+	bits   int // 数据所属网络在搜索树中的前缀长度, set 为 true 时有效
+	set    bool
+}
+
+// New 创建 Writer
+func New(meta Metadata) *Writer {
+	w := &Writer{meta: meta, root: &node{}, cache: map[string]int{}}
+	n := w.root
+	for i := 0; i < 96; i++ {
+		split(&n.child[0])
+		n = n.child[0].node
+	}
+	w.v4 = n
+	return w
+}
+
+// Networks 返回已插入的网络数量
+func (w *Writer) Networks() int {
+	return w.networks
+}
+
+// Records 返回数据段中去重后的记录数量
+func (w *Writer) Records() int {
+	return len(w.cache)
+}
+
+// Insert 插入网络及其记录
+//
+// 存在包含关系时更具体的网络优先, 与插入顺序无关, 相同网络后插入的覆盖先插入的;
+// IPv4 网络位于 ::/96 下, 与查询接口一致, IPv4 与 IPv6 的数据互不覆盖: ::/0 等包含 ::/96 的 IPv6 网络不写入 ::/96;
+// value 支持 string、bool、float32、float64、int、int32、uint16、uint32、uint64、[]byte 以及由它们组成的 map 与切片
+func (w *Writer) Insert(p netip.Prefix, value any) error {
+	if !p.IsValid() {
+		return fmt.Errorf("无效的网络: %s", p)
+	}
+	if IPv4Reserved(p) {
+		return fmt.Errorf("IPv6 网络 %s 位于表示 IPv4 的 ::/96 内", p)
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, value); err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	offset, ok := w.cache[buf.String()]
+	if !ok {
+		offset = w.data.Len()
+		w.data.Write(buf.Bytes())
+		w.cache[buf.String()] = offset
+	}
+
+	addr, bits := treePath(p.Masked())
+	if p.Addr().Is4() {
+		w.v4data = true
+	}
+	w.insert(addr, bits, record{offset: offset, set: true}, p.Addr().Is4())
+	w.networks++
+	return nil
+}
+
+// ipv4Space 搜索树中 IPv4 数据所在的 ::/96
+var ipv4Space = netip.MustParsePrefix("::/96")
+
+// IPv4Reserved 是否为 ::/96 内 (含 ::/96 本身) 的 IPv6 网络, 这部分地址在文件中表示 IPv4, 无法写入
+func IPv4Reserved(p netip.Prefix) bool {
+	return p.Addr().Is6() && !p.Addr().Is4In6() && p.Bits() >= 96 && ipv4Space.Contains(p.Addr())
+}
+
+// treePath 返回网络在 IPv6 搜索树中的路径, IPv4 映射到 ::/96
+func treePath(p netip.Prefix) ([16]byte, int) {
+	addr := p.Addr()
+	if addr.Is4() {
+		var path [16]byte
+		v4 := addr.As4()
+		copy(path[12:], v4[:])
+		return path, 96 + p.Bits()
+	}
+	return addr.As16(), p.Bits()
+}
+
+func (w *Writer) insert(addr [16]byte, bits int, r record, v4 bool) {
+	r.bits = bits
+	if bits == 0 {
+		w.fill(&w.root.child[0], r, v4)
+		w.fill(&w.root.child[1], r, v4)
+		return
+	}
+
+	n := w.root
+	for i := 0; i < bits; i++ {
+		next := &n.child[bitAt(addr, i)]
+		if i == bits-1 {
+			w.fill(next, r, v4)
+			return
+		}
+		split(next)
+		n = next.node
+	}
+}
+
+// split 将数据记录或空记录拆分为子节点, 两个子记录继承原有数据
+func split(r *record) {
+	if r.node != nil {
+		return
+	}
+	child := &node{}
+	if r.set {
+		child.child = [2]record{*r, *r}
+	}
+	*r = record{node: child}
+}
+
+// fill 用 r 覆盖子树中的空记录和来自不比 r 更具体的网络的记录, 保留更具体网络的数据
+// IPv6 网络不进入 IPv4 子树
+func (w *Writer) fill(cur *record, r record, v4 bool) {
+	switch {
+	case cur.node == w.v4 && !v4:
+		// 跳过 IPv4 子树
+	case cur.node != nil:
+		w.fill(&cur.node.child[0], r, v4)
+		w.fill(&cur.node.child[1], r, v4)
+	case !cur.set || cur.bits <= r.bits:
+		*cur = r
+	}
+}
+
+// alias 将 path/bits 位置指向 target 节点, 该位置已有同样或更具体的数据时跳过
+func (w *Writer) alias(addr [16]byte, bits int, target *node) {
+	n := w.root
+	for i := 0; i < bits; i++ {
+		next := &n.child[bitAt(addr, i)]
+		if i == bits-1 {
+			if next.node == nil && (!next.set || next.bits < bits) {
+				*next = record{node: target}
+			}
+			return
+		}
+		// 路径上的数据来自更短的网络, 拆分后继续
+		split(next)
+		n = next.node
+	}
+}
+
+// WriteTo 输出完整的 .mmdb 文件
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	if w.v4data {
+		w.alias(netip.MustParseAddr("::ffff:0:0").As16(), 96, w.v4)
+		w.alias(netip.MustParseAddr("2002::").As16(), 16, w.v4)
+	}
+
+	// 广度优先编号, 根节点为 0, 别名指向的节点只编号一次
+	index := map[*node]int{w.root: 0}
+	nodes := []*node{w.root}
+	for i := 0; i < len(nodes); i++ {
+		for _, r := range nodes[i].child {
+			if r.node != nil {
+				if _, ok := index[r.node]; !ok {
+					index[r.node] = len(nodes)
+					nodes = append(nodes, r.node)
+				}
+			}
+		}
+	}
+
+	nodeCount := len(nodes)
+	maxValue := uint64(nodeCount) + 16 + uint64(w.data.Len())
+	recordSize := 0
+	for _, size := range []int{24, 28, 32} {
+		if maxValue < 1<<size {
+			recordSize = size
+			break
+		}
+	}
+	if recordSize == 0 {
+		return 0, fmt.Errorf("数据过大, 超出 32 位记录的寻址范围")
+	}
+
+	value := func(r record) uint32 {
+		switch {
+		case r.node != nil:
+			return uint32(index[r.node])
+		case r.set:
+			return uint32(nodeCount + 16 + r.offset)
+		}
+		return uint32(nodeCount)
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		left, right := value(n.child[0]), value(n.child[1])
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left),
+				byte((left>>24)&0x0F)<<4 | byte((right>>24)&0x0F),
+				byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			buf.Write(binary.BigEndian.AppendUint32(nil, left))
+			buf.Write(binary.BigEndian.AppendUint32(nil, right))
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(w.data.Bytes())
+	buf.Write(metadataMarker)
+
+	epoch := w.meta.BuildEpoch
+	if epoch.IsZero() {
+		epoch = time.Now()
+	}
+	description := map[string]any{}
+	for k, v := range w.meta.Description {
+		description[k] = v
+	}
+	languages := []any{}
+	for _, v := range w.meta.Languages {
+		languages = append(languages, v)
+	}
+	meta := map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(epoch.Unix()),
+		"database_type":               w.meta.DatabaseType,
+		"description":                 description,
+		"ip_version":                  uint16(6),
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	}
+	if err := encode(&buf, meta); err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(out)
+}
+
+// encode 按数据段格式编码一个值
+func encode(buf *bytes.Buffer, v any) error {
+	switch val := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(val))
+		buf.WriteString(val)
+	case []byte:
+		writeControl(buf, typeBytes, len(val))
+		buf.Write(val)
+	case bool:
+		size := 0
+		if val {
+			size = 1
+		}
+		writeControl(buf, typeBoolean, size)
+	case float64:
+		writeControl(buf, typeDouble, 8)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(val)))
+	case float32:
+		writeControl(buf, typeFloat, 4)
+		buf.Write(binary.BigEndian.AppendUint32(nil, math.Float32bits(val)))
+	case uint16:
+		writeUint(buf, typeUint16, uint64(val))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(val))
+	case uint64:
+		writeUint(buf, typeUint64, val)
+	case int32:
+		if val >= 0 {
+			writeUint(buf, typeInt32, uint64(val))
+		} else {
+			writeControl(buf, typeInt32, 4)
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(val)))
+		}
+	case int:
+		switch {
+		case val >= 0 && val <= math.MaxUint32:
+			writeUint(buf, typeUint32, uint64(val))
+		case val > math.MaxUint32:
+			writeUint(buf, typeUint64, uint64(val))
+		case val >= math.MinInt32:
+			return encode(buf, int32(val))
+		default:
+			return fmt.Errorf("整数超出 int32 范围: %d", val)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeControl(buf, typeMap, len(keys))
+		for _, k := range keys {
+			encode(buf, k)
+			if err := encode(buf, val[k]); err != nil {
+				return fmt.Errorf("%s: %v", k, err)
+			}
+		}
+	case map[string]string:
+		m := make(map[string]any, len(val))
+		for k, v := range val {
+			m[k] = v
+		}
+		return encode(buf, m)
+	case []any:
+		writeControl(buf, typeArray, len(val))
+		for _, item := range val {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	case []string:
+		writeControl(buf, typeArray, len(val))
+		for _, item := range val {
+			encode(buf, item)
+		}
+	default:
+		return fmt.Errorf("不支持的数据类型: %T", v)
+	}
+	return nil
+}
+
+// writeUint 使用最少的字节数编码无符号整数
+func writeUint(buf *bytes.Buffer, typ int, v uint64) {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	writeControl(buf, typ, len(b))
+	buf.Write(b)
+}
+
+// writeControl 写入控制字节, 扩展类型和较大的长度使用额外的字节
+func writeControl(buf *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	if typ <= 7 {
+		ctrl = byte(typ) << 5
+	}
+
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		extra = binary.BigEndian.AppendUint16(nil, uint16(size-285))
+	default:
+		ctrl |= 31
+		n := size - 65821
+		extra = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+
+	buf.WriteByte(ctrl)
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	buf.Write(extra)
+}
+
+func bitAt(addr [16]byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
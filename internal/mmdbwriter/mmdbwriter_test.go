@@ -0,0 +1,145 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func build(t *testing.T, prefixes []string) []byte {
+	t.Helper()
+	w := New(Metadata{DatabaseType: "GeoIP2-City", Languages: []string{"en"}, Description: map[string]string{"en": "test"}})
+	for _, p := range prefixes {
+		value := map[string]any{
+			"name":    p,
+			"country": map[string]any{"iso_code": p},
+		}
+		if err := w.Insert(netip.MustParsePrefix(p), value); err != nil {
+			t.Fatalf("Insert(%s): %v", p, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	prefixes := []string{"::/0", "1.0.0.0/8", "1.2.3.0/24", "1.2.3.128/25", "2001:db8::/32", "2001:db8:1::/48", "::/8"}
+	lookups := []struct {
+		ip   string
+		want string // 为空表示不应命中
+	}{
+		{"1.2.3.4", "1.2.3.0/24"},
+		{"1.2.3.200", "1.2.3.128/25"},
+		{"1.9.9.9", "1.0.0.0/8"},
+		{"::ffff:1.2.3.4", "1.2.3.0/24"},
+		{"2002:102:304::1", "1.2.3.0/24"},
+		{"2001:db8:1::1", "2001:db8:1::/48"},
+		{"2001:db8:2::1", "2001:db8::/32"},
+		{"2400::1", "::/0"},
+		// 存在 IPv4 数据时 ::/96 只属于 IPv4, 与查询接口一致
+		{"9.9.9.9", ""},
+		{"::1", ""},
+	}
+
+	// 正序、倒序和交错顺序写入的结果应一致
+	orders := [][]string{
+		prefixes,
+		{"1.2.3.128/25", "2001:db8:1::/48", "1.2.3.0/24", "::/8", "2001:db8::/32", "1.0.0.0/8", "::/0"},
+		{"1.0.0.0/8", "1.2.3.128/25", "::/0", "2001:db8:1::/48", "1.2.3.0/24", "::/8", "2001:db8::/32"},
+	}
+	for _, order := range orders {
+		data := build(t, order)
+		db, err := maxminddb.FromBytes(data)
+		if err != nil {
+			t.Fatalf("FromBytes: %v", err)
+		}
+		if err := db.Verify(); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+
+		for _, l := range lookups {
+			var got struct {
+				Name string `maxminddb:"name"`
+			}
+			if err := db.Lookup(net.ParseIP(l.ip), &got); err != nil {
+				t.Fatalf("Lookup(%s): %v", l.ip, err)
+			}
+			if got.Name != l.want {
+				t.Errorf("order %v: Lookup(%s) = %q, want %q", order, l.ip, got.Name, l.want)
+			}
+		}
+
+		city, err := geoip2.FromBytes(data)
+		if err != nil {
+			t.Fatalf("geoip2.FromBytes: %v", err)
+		}
+		record, err := city.City(net.ParseIP("1.2.3.4"))
+		if err != nil || record.Country.IsoCode != "1.2.3.0/24" {
+			t.Errorf("geoip2 City(1.2.3.4) = %q, %v", record.Country.IsoCode, err)
+		}
+		db.Close()
+	}
+}
+
+func TestIPv6Only(t *testing.T) {
+	db, err := maxminddb.FromBytes(build(t, []string{"::/8", "::/0", "2001:db8::/32"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ::/96 在 MaxMind DB 中表示 IPv4, 没有 IPv4 数据时也不属于 IPv6 网络
+	for ip, want := range map[string]string{"::1": "", "1.2.3.4": "", "::1:0:0:1": "::/8", "2001:db8::1": "2001:db8::/32", "2400::1": "::/0"} {
+		var got struct {
+			Name string `maxminddb:"name"`
+		}
+		if err := db.Lookup(net.ParseIP(ip), &got); err != nil || got.Name != want {
+			t.Errorf("Lookup(%s) = %q, %v, want %q", ip, got.Name, err, want)
+		}
+	}
+}
+
+func TestInsertOverwrite(t *testing.T) {
+	w := New(Metadata{DatabaseType: "Test"})
+	w.Insert(netip.MustParsePrefix("10.0.0.0/8"), "a")
+	w.Insert(netip.MustParsePrefix("10.0.0.0/8"), "b")
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	db, err := maxminddb.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := db.Lookup(net.ParseIP("10.1.2.3"), &got); err != nil || got != "b" {
+		t.Errorf("same network should be overwritten by the later insert, got %q, %v", got, err)
+	}
+	if w.Records() != 2 || w.Networks() != 2 {
+		t.Errorf("Records() = %d, Networks() = %d", w.Records(), w.Networks())
+	}
+}
+
+func TestEncodeDedupe(t *testing.T) {
+	w := New(Metadata{DatabaseType: "Test"})
+	for _, p := range []string{"1.0.0.0/8", "2.0.0.0/8", "2001:db8::/32"} {
+		w.Insert(netip.MustParsePrefix(p), map[string]any{"v": uint32(1), "s": []string{"x"}})
+	}
+	if w.Records() != 1 {
+		t.Errorf("identical values should be stored once, Records() = %d", w.Records())
+	}
+	if err := w.Insert(netip.Prefix{}, "x"); err == nil {
+		t.Errorf("invalid prefix should fail")
+	}
+	if err := w.Insert(netip.MustParsePrefix("::1/128"), "x"); err == nil {
+		t.Errorf("IPv6 network inside ::/96 should fail")
+	}
+	if err := w.Insert(netip.MustParsePrefix("3.0.0.0/8"), struct{}{}); err == nil {
+		t.Errorf("unsupported type should fail")
+	}
+}
@@ -0,0 +1,238 @@
+// Package proxyproto 为 net.Listener 增加 HAProxy PROXY protocol v1/v2 支持
+//
+// 只解析来自可信上游的连接, 其他连接原样透传; 头部在第一次 Read 或 RemoteAddr 时解析, 不阻塞 Accept
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v1 头部最长 107 字节, v2 签名固定 12 字节
+const v1MaxLength = 107
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// ParseUpstream 解析上游地址列表, 支持 CIDR 和单个 IP, 空字符串被忽略
+func ParseUpstream(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, err2 := netip.ParseAddr(s)
+			if err2 != nil {
+				return nil, fmt.Errorf("无效的上游地址: %s", s)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Listener 解析 PROXY protocol 头部的监听器
+type Listener struct {
+	net.Listener
+	upstream []netip.Prefix
+	timeout  time.Duration
+}
+
+// NewListener 包装监听器, upstream 为允许发送 PROXY 头部的上游地址, timeout 为读取头部的超时时间, 0 表示不限
+func NewListener(inner net.Listener, upstream []netip.Prefix, timeout time.Duration) *Listener {
+	return &Listener{Listener: inner, upstream: upstream, timeout: timeout}
+}
+
+// Accept 接受连接, 来自可信上游的连接包装为 Conn
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReaderSize(conn, 256), timeout: l.timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range l.upstream {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn 来自可信上游的连接, 头部中的源地址和目标地址替换 RemoteAddr 与 LocalAddr
+// 上游没有发送头部时按普通连接处理, 头部格式错误时连接的读取返回错误
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	err     error
+	remote  net.Addr
+	local   net.Addr
+}
+
+// Read 读取头部之后的数据
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.parse)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr 返回头部中的源地址, 没有头部时返回连接的对端地址
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.parse)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr 返回头部中的目标地址, 没有头部时返回连接的本地地址
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.parse)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// parse 读取并解析头部
+func (c *Conn) parse() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	// 对端不发送任何数据时 Peek 会一直等待到超时, 数据不足签名长度时按普通连接处理
+	peek, err := c.reader.Peek(len(v1Prefix))
+	if err != nil {
+		if len(peek) == 0 {
+			c.err = err
+		}
+		return
+	}
+	switch {
+	case bytes.Equal(peek, v1Prefix):
+		c.err = c.parseV1()
+	case bytes.Equal(peek, v2Signature[:len(v1Prefix)]):
+		c.err = c.parseV2()
+	}
+	if c.err != nil {
+		c.err = fmt.Errorf("proxyproto: %w", c.err)
+	}
+}
+
+// parseV1 解析文本格式: PROXY TCP4|TCP6|UNKNOWN 源地址 目标地址 源端口 目标端口\r\n
+func (c *Conn) parseV1() error {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("v1 头部超过 %d 字节或缺少 CRLF", v1MaxLength)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("无效的 v1 头部: %q", line)
+	}
+	src, err1 := netip.ParseAddr(fields[2])
+	dst, err2 := netip.ParseAddr(fields[3])
+	sport, err3 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err4 := strconv.ParseUint(fields[5], 10, 16)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || src.Is4() != (fields[1] == "TCP4") {
+		return fmt.Errorf("无效的 v1 头部: %q", line)
+	}
+	c.remote = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, uint16(sport)))
+	c.local = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, uint16(dport)))
+	return nil
+}
+
+// parseV2 解析二进制格式, 只使用 TCP/UDP over IPv4/IPv6 的地址, 忽略 TLV
+func (c *Conn) parseV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:12], v2Signature) {
+		return fmt.Errorf("无效的 v2 签名")
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("不支持的 v2 版本: %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+
+	// LOCAL 命令为上游自身的健康检查等连接, 使用连接的真实地址
+	switch header[12] & 0x0F {
+	case 0x0:
+		return nil
+	case 0x1:
+	default:
+		return fmt.Errorf("不支持的 v2 命令: %d", header[12]&0x0F)
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 0x1:
+		size = 4
+	case 0x2:
+		size = 16
+	default:
+		// AF_UNSPEC 与 AF_UNIX 没有可用的 IP 地址
+		return nil
+	}
+	if len(payload) < size*2+4 {
+		return fmt.Errorf("v2 地址长度不足: %d", len(payload))
+	}
+	src, _ := netip.AddrFromSlice(payload[:size])
+	dst, _ := netip.AddrFromSlice(payload[size : size*2])
+	sport := binary.BigEndian.Uint16(payload[size*2:])
+	dport := binary.BigEndian.Uint16(payload[size*2+2:])
+	c.remote = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, sport))
+	c.local = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, dport))
+	return nil
+}
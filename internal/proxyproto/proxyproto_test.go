@@ -0,0 +1,134 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// fakeConn 从固定数据读取的连接
+type fakeConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *fakeConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+}
+
+func (c *fakeConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80}
+}
+
+func newConn(data []byte) *Conn {
+	inner := &fakeConn{r: bytes.NewReader(data)}
+	return &Conn{Conn: inner, reader: bufio.NewReaderSize(inner, 256)}
+}
+
+// v2 构造 v2 头部, addrs 为源地址、目标地址、源端口、目标端口的二进制表示
+func v2(command, family byte, addrs []byte) []byte {
+	header := append(slices.Clone(v2Signature), 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func v2Addrs(src, dst string, sport, dport uint16) []byte {
+	b := append(netip.MustParseAddr(src).AsSlice(), netip.MustParseAddr(dst).AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, sport)
+	return binary.BigEndian.AppendUint16(b, dport)
+}
+
+func TestHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		remote string // 为空表示头部无效
+		local  string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 443\r\n"), "1.2.3.4:1234", "5.6.7.8:443"},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n"), "[2001:db8::1]:1234", "[2001:db8::2]:443"},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "10.0.0.1:1000", "10.0.0.2:80"},
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::1 2001:db8::2 1234 443\r\n"), "", ""},
+		{"v1 bad port", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 70000 443\r\n"), "", ""},
+		{"v1 missing crlf", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 443\n"), "", ""},
+		{"v1 too long", append([]byte("PROXY "), bytes.Repeat([]byte("x"), v1MaxLength)...), "", ""},
+		{"v2 tcp4", v2(0x1, 0x11, v2Addrs("1.2.3.4", "5.6.7.8", 1234, 443)), "1.2.3.4:1234", "5.6.7.8:443"},
+		{"v2 tcp6", v2(0x1, 0x21, v2Addrs("2001:db8::1", "2001:db8::2", 1234, 443)), "[2001:db8::1]:1234", "[2001:db8::2]:443"},
+		{"v2 tlv ignored", v2(0x1, 0x11, append(v2Addrs("1.2.3.4", "5.6.7.8", 1234, 443), 0x04, 0x00, 0x01, 0xff)), "1.2.3.4:1234", "5.6.7.8:443"},
+		{"v2 local", v2(0x0, 0x00, nil), "10.0.0.1:1000", "10.0.0.2:80"},
+		{"v2 unspec", v2(0x1, 0x00, nil), "10.0.0.1:1000", "10.0.0.2:80"},
+		{"v2 short address", v2(0x1, 0x11, []byte{1, 2, 3, 4}), "", ""},
+		{"v2 bad command", v2(0x2, 0x11, v2Addrs("1.2.3.4", "5.6.7.8", 1234, 443)), "", ""},
+		{"v2 truncated", func() []byte {
+			header := v2(0x1, 0x11, nil)
+			binary.BigEndian.PutUint16(header[14:], 512)
+			return header
+		}(), "", ""},
+		{"no header", nil, "10.0.0.1:1000", "10.0.0.2:80"},
+	}
+	for _, tt := range tests {
+		conn := newConn(append(slices.Clone(tt.header), "GET / HTTP/1.1\r\n"...))
+		body, err := io.ReadAll(conn)
+		if tt.remote == "" {
+			if err == nil {
+				t.Errorf("%s: invalid header should fail", tt.name)
+			}
+			continue
+		}
+		if err != nil || string(body) != "GET / HTTP/1.1\r\n" {
+			t.Errorf("%s: Read = %q, %v", tt.name, body, err)
+		}
+		if got := conn.RemoteAddr().String(); got != tt.remote {
+			t.Errorf("%s: RemoteAddr = %s, want %s", tt.name, got, tt.remote)
+		}
+		if got := conn.LocalAddr().String(); got != tt.local {
+			t.Errorf("%s: LocalAddr = %s, want %s", tt.name, got, tt.local)
+		}
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	got, err := ParseUpstream([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::ffff:172.16.0.1", "2001:db8::1/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("172.16.0.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseUpstream = %v, want %v", got, want)
+	}
+	if _, err := ParseUpstream([]string{"example.com"}); err == nil {
+		t.Errorf("invalid upstream should fail")
+	}
+}
+
+func TestListenerTrusted(t *testing.T) {
+	l := NewListener(nil, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, 0)
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("11.1.2.3")}, false},
+		{&net.UnixAddr{Name: "/tmp/sock"}, false},
+	}
+	for _, tt := range tests {
+		if got := l.trusted(tt.addr); got != tt.want {
+			t.Errorf("trusted(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/lwmacct/250402-m-geoip/app"
 	"github.com/lwmacct/250402-m-geoip/app/client"
+	"github.com/lwmacct/250402-m-geoip/app/export"
+	"github.com/lwmacct/250402-m-geoip/app/importer"
 	"github.com/lwmacct/250402-m-geoip/app/server"
 	"github.com/lwmacct/250402-m-geoip/app/start"
 	"github.com/lwmacct/250402-m-geoip/app/version"
@@ -26,6 +28,12 @@ func main() {
 		// 如果程序只有一个命令, 建议使用 start 入口
 		mc.AddCobra(start.Cmd().Cobra())
 
+		// 数据导入
+		mc.AddCobra(importer.Cmd().Cobra())
+
+		// 数据导出
+		mc.AddCobra(export.Cmd().Cobra())
+
 		// 客户端, 当指定的环境变量正确时, 会自动添加此命令, 可以设置自己的 salt
 		if os.Getenv("ACF_CLIENT_FLAG") == "1" {
 			mc.AddCobra(client.Cmd().Cobra())